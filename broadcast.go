@@ -0,0 +1,228 @@
+package openwechat
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/gonebot-dev/gonebot/logging"
+	"github.com/gonebot-dev/gonebot/message"
+	"github.com/rs/zerolog"
+)
+
+// BroadcastAction sends the message to every target through the normal send path,
+// call it with OpenWechat.CallAction and the result is a BroadcastReport.
+type BroadcastAction struct {
+	// Friends to send to, in any form Message.Receiver accepts
+	Friends []string
+	// Groups to send to, in any form Message.Group accepts
+	Groups []string
+	// Also send to friends whose remark or nickname matches the regexp, empty selects none
+	FriendSelector string
+	// Also send to groups whose name matches the regexp, empty selects none
+	GroupSelector string
+	// The message to send, its Receiver and Group are replaced for every target
+	Message message.Message
+}
+
+// BroadcastResult is the delivery result of one target.
+type BroadcastResult struct {
+	// Friend or group as given or selected
+	Target  string
+	IsGroup bool
+	// Nil if the message is delivered
+	Err error
+}
+
+// BroadcastReport is the result of a BroadcastAction, Err is set if the targets cannot be resolved.
+type BroadcastReport struct {
+	Results   []BroadcastResult
+	Succeeded int
+	Failed    int
+	Err       error
+}
+
+// Collect the targets of a broadcast, targets resolving to the same contact are sent once
+// and targets that cannot be resolved are reported with their errors.
+func broadcastTargets(action BroadcastAction) ([]BroadcastResult, error) {
+	self, err := currentSelf()
+	if err != nil {
+		return nil, err
+	}
+	targets := []BroadcastResult{}
+	seen := map[string]bool{}
+	add := func(target string, isGroup bool) {
+		var key string
+		if isGroup {
+			group, err := findGroup(target)
+			if err != nil {
+				targets = append(targets, BroadcastResult{Target: target, IsGroup: true, Err: err})
+				return
+			}
+			key = conversationName("", group.UserName)
+		} else {
+			friend, err := findFriend(target)
+			if err != nil {
+				targets = append(targets, BroadcastResult{Target: target, Err: err})
+				return
+			}
+			key = conversationName(friend.UserName, "")
+		}
+		if !seen[key] {
+			seen[key] = true
+			targets = append(targets, BroadcastResult{Target: target, IsGroup: isGroup})
+		}
+	}
+	for _, friend := range action.Friends {
+		add(friend, false)
+	}
+	for _, group := range action.Groups {
+		add(group, true)
+	}
+	if action.FriendSelector != "" {
+		selector, err := regexp.Compile(action.FriendSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid friend selector: %w", err)
+		}
+		friends, err := self.Friends()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get friends: %w", err)
+		}
+		for _, friend := range friends {
+			if selector.MatchString(friend.RemarkName) || selector.MatchString(friend.NickName) {
+				add(friend.UserName, false)
+			}
+		}
+	}
+	if action.GroupSelector != "" {
+		selector, err := regexp.Compile(action.GroupSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group selector: %w", err)
+		}
+		groups, err := self.Groups()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get groups: %w", err)
+		}
+		for _, group := range groups {
+			if selector.MatchString(group.NickName) {
+				add(group.UserName, true)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// Queue the message for every target and wait for all of them.
+func broadcast(action BroadcastAction) BroadcastReport {
+	targets, err := broadcastTargets(action)
+	if err != nil {
+		return BroadcastReport{Err: err}
+	}
+	if len(targets) == 0 {
+		return BroadcastReport{Err: errors.New("no broadcast targets")}
+	}
+	// Fetch and prepare media once instead of once per target
+	tempDir, err := os.MkdirTemp("", "openwechat-broadcast-*")
+	if err != nil {
+		return BroadcastReport{Err: fmt.Errorf("unable to create temp folder: %w", err)}
+	}
+	defer os.RemoveAll(tempDir)
+	prepared := preloadMedia(action.Message, tempDir)
+	var wg sync.WaitGroup
+	for i := range targets {
+		if targets[i].Err != nil {
+			continue
+		}
+		msg := prepared
+		msg.Receiver, msg.Group = "", ""
+		if targets[i].IsGroup {
+			msg.Group = targets[i].Target
+		} else {
+			msg.Receiver = targets[i].Target
+		}
+		wg.Add(1)
+		sendQueue.push(msg, func(err error) {
+			targets[i].Err = err
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	report := BroadcastReport{Results: targets}
+	for _, result := range targets {
+		if result.Err == nil {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// Replace remote and inline media of the message with prepared local files in dir,
+// segments that fail to load are kept as they are and fail for every target.
+func preloadMedia(msg message.Message, dir string) message.Message {
+	preloaded := message.NewMessage()
+	preloaded.IsToMe = msg.IsToMe
+	preloaded.Self = msg.Self
+	for i, segment := range msg.GetSegments() {
+		switch data := segment.Data.(type) {
+		case message.ImageType:
+			data.File = preloadFile(data.File, dir, i, "image", prepareImage)
+			segment.Data = data
+		case message.FileType:
+			data.File = preloadFile(data.File, dir, i, "file", nil)
+			segment.Data = data
+		case message.VideoType:
+			data.File = preloadFile(data.File, dir, i, "video", nil)
+			segment.Data = data
+		case message.VoiceType:
+			data.File = preloadFile(data.File, dir, i, "voice", nil)
+			segment.Data = data
+		case StickerType:
+			data.File = preloadFile(data.File, dir, i, "sticker", nil)
+			segment.Data = data
+		}
+		preloaded.AttachSegment(segment)
+	}
+	return *preloaded
+}
+
+// Load a media file into dir and return its path, local files and failures return the file as is.
+func preloadFile(file, dir string, index int, kind string, prepare func(*mediaData) (*mediaData, error)) string {
+	if file == "" || !(isURL(file) || isBase64Img(file) || isDataURI(file)) {
+		return file
+	}
+	media, err := loadMedia(file)
+	if err == nil && prepare != nil {
+		media, err = prepare(media)
+	}
+	if err != nil {
+		logging.Logf(zerolog.WarnLevel, "OpenWechat", "preloadMedia: Unable to load %s %s: %s", kind, sourceName(file), err.Error())
+		return file
+	}
+	// Keep the file name for file sends and the format for voice
+	name := media.name
+	if kind == "voice" {
+		name = "voice"
+		if format := detectVoiceFormat(media.data); format != "unknown" {
+			name += "." + format
+		}
+	}
+	if name == "" {
+		name = kind
+	}
+	segmentDir := filepath.Join(dir, strconv.Itoa(index))
+	path := filepath.Join(segmentDir, name)
+	if err = os.MkdirAll(segmentDir, os.ModePerm); err == nil {
+		err = os.WriteFile(path, media.data, 0644)
+	}
+	if err != nil {
+		logging.Logf(zerolog.WarnLevel, "OpenWechat", "preloadMedia: Unable to write %s: %s", kind, err.Error())
+		return file
+	}
+	return path
+}
@@ -0,0 +1,148 @@
+package openwechat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/eatmoreapple/openwechat"
+)
+
+// ErrAmbiguousContact is returned when a receiver or group matches more than one contact.
+var ErrAmbiguousContact = errors.New("ambiguous contact")
+
+// Prefixes of receivers and groups resolved from the contact cache instead of the session UserName.
+// There is no id prefix as WeChat web has no stable contact id, Uin is always 0 and AvatarID changes with the avatar.
+var contactMatchers = map[string]func(user *openwechat.User, value string) bool{
+	"remark:": func(user *openwechat.User, value string) bool { return user.RemarkName == value },
+	"nick:":   func(user *openwechat.User, value string) bool { return user.NickName == value },
+	"alias:":  func(user *openwechat.User, value string) bool { return user.Alias == value },
+}
+
+// Split a contact reference into its matcher and value, the matcher is nil for plain UserNames.
+func parseContactRef(ref string) (func(user *openwechat.User, value string) bool, string) {
+	for prefix, matcher := range contactMatchers {
+		if value, ok := strings.CutPrefix(ref, prefix); ok {
+			return matcher, value
+		}
+	}
+	return nil, ref
+}
+
+// Find the only user matching the reference.
+func resolveContact(users openwechat.Members, ref string) (*openwechat.User, error) {
+	matcher, value := parseContactRef(ref)
+	var found *openwechat.User
+	for _, user := range users {
+		if !matcher(user, value) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%w: %s matches both %s and %s", ErrAmbiguousContact, ref, found.UserName, user.UserName)
+		}
+		found = user
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrContactNotFound, ref)
+	}
+	return found, nil
+}
+
+// Find a friend by UserName or a prefixed reference like "remark:Alice".
+func findFriend(receiver string) (*openwechat.Friend, error) {
+	self, err := currentSelf()
+	if err != nil {
+		return nil, err
+	}
+	friends, err := self.Friends()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get friends: %w", err)
+	}
+	if matcher, _ := parseContactRef(receiver); matcher == nil {
+		friend := searchFriend(self, friends, receiver)
+		if friend == nil {
+			return nil, fmt.Errorf("%w: friend %s", ErrContactNotFound, receiver)
+		}
+		return friend, nil
+	}
+	user, err := resolveContact(friends.AsMembers(), receiver)
+	if err != nil {
+		return nil, fmt.Errorf("friend: %w", err)
+	}
+	return &openwechat.Friend{User: user}, nil
+}
+
+// Find a group by UserName or a prefixed reference like "nick:My Group".
+func findGroup(group string) (*openwechat.Group, error) {
+	self, err := currentSelf()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := self.Groups()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get groups: %w", err)
+	}
+	if matcher, _ := parseContactRef(group); matcher == nil {
+		found := groups.SearchByUserName(1, group).First()
+		if found == nil {
+			return nil, fmt.Errorf("%w: group %s", ErrContactNotFound, group)
+		}
+		return found, nil
+	}
+	user, err := resolveContact(groups.AsMembers(), group)
+	if err != nil {
+		return nil, fmt.Errorf("group: %w", err)
+	}
+	return &openwechat.Group{User: user}, nil
+}
+
+// Convert a receiver or group to a reference that survives restarts. Session UserNames are replaced
+// by remark or nickname references, which must match exactly the same contact.
+func stableContactRef(ref string, isGroup bool) (string, error) {
+	if matcher, _ := parseContactRef(ref); matcher != nil || ref == openwechat.FileHelper {
+		return ref, nil
+	}
+	self, err := currentSelf()
+	if err != nil {
+		return "", err
+	}
+	var user *openwechat.User
+	var users openwechat.Members
+	if isGroup {
+		group, err := findGroup(ref)
+		if err != nil {
+			return "", err
+		}
+		groups, err := self.Groups()
+		if err != nil {
+			return "", fmt.Errorf("unable to get groups: %w", err)
+		}
+		user, users = group.User, groups.AsMembers()
+	} else {
+		friend, err := findFriend(ref)
+		if err != nil {
+			return "", err
+		}
+		if friend.UserName == openwechat.FileHelper {
+			return openwechat.FileHelper, nil
+		}
+		friends, err := self.Friends()
+		if err != nil {
+			return "", fmt.Errorf("unable to get friends: %w", err)
+		}
+		user, users = friend.User, friends.AsMembers()
+	}
+	candidates := []string{}
+	if user.RemarkName != "" {
+		candidates = append(candidates, "remark:"+user.RemarkName)
+	}
+	if user.NickName != "" {
+		candidates = append(candidates, "nick:"+user.NickName)
+	}
+	for _, candidate := range candidates {
+		if found, err := resolveContact(users, candidate); err == nil && found.UserName == user.UserName {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s has no unique remark or nickname", ErrAmbiguousContact, ref)
+}
@@ -0,0 +1,83 @@
+package openwechat
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gonebot-dev/gonebot/message"
+)
+
+// ParseFaces controls whether WeChat emoji codes such as [微笑] in incoming text
+// are parsed into face segments.
+var ParseFaces = false
+
+var faceCodeRegexp = regexp.MustCompile(`\[[^\[\]]+\]`)
+
+// All the WeChat built-in emoji codes, ordered by their face IDs.
+var faceCodes []string
+
+// Face code by English name (e.g. Smile), Chinese name (e.g. 微笑) or the code itself.
+var faceCodeByName = make(map[string]string)
+
+// Face ID by face code.
+var faceIDByCode = make(map[string]int)
+
+// Face English name by face code.
+var faceNameByCode = make(map[string]string)
+
+func init() {
+	emoji := reflect.ValueOf(Emoji)
+	for i := 0; i < emoji.NumField(); i++ {
+		name := emoji.Type().Field(i).Name
+		code := emoji.Field(i).String()
+		faceCodes = append(faceCodes, code)
+		faceCodeByName[name] = code
+		faceCodeByName[strings.ToLower(name)] = code
+		faceCodeByName[strings.Trim(code, "[]")] = code
+		faceCodeByName[code] = code
+		faceIDByCode[code] = i
+		faceNameByCode[code] = name
+	}
+}
+
+// Get the emoji code of a face, returns empty string if not found.
+func faceCode(face FaceType) string {
+	if face.Name != "" {
+		if code, ok := faceCodeByName[face.Name]; ok {
+			return code
+		}
+		return faceCodeByName[strings.ToLower(face.Name)]
+	}
+	if face.ID >= 0 && face.ID < len(faceCodes) {
+		return faceCodes[face.ID]
+	}
+	return ""
+}
+
+// Attach text to the message, splitting emoji codes into face segments if ParseFaces is set.
+func attachText(msg *message.Message, text string) {
+	if !ParseFaces {
+		msg.Text(text)
+		return
+	}
+	last := 0
+	for _, loc := range faceCodeRegexp.FindAllStringIndex(text, -1) {
+		code := text[loc[0]:loc[1]]
+		id, ok := faceIDByCode[code]
+		if !ok {
+			continue
+		}
+		if loc[0] > last {
+			msg.Text(text[last:loc[0]])
+		}
+		msg.Any(FaceType{
+			Name: faceNameByCode[code],
+			ID:   id,
+		})
+		last = loc[1]
+	}
+	if last < len(text) || last == 0 {
+		msg.Text(text[last:])
+	}
+}
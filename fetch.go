@@ -0,0 +1,290 @@
+package openwechat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FetchTimeout is the timeout of fetching outgoing media from urls, including reading the body.
+var FetchTimeout = 30 * time.Second
+
+// FetchMaxBytes is the max size of media fetched from urls, set it to 0 to disable the limit.
+var FetchMaxBytes int64 = 50 << 20
+
+// FetchProxy is the HTTP proxy used to fetch media, like "http://127.0.0.1:7890",
+// leave it empty to use the HTTP_PROXY and HTTPS_PROXY environment variables.
+var FetchProxy = ""
+
+// FetchAllowHosts are hosts that can always be fetched, even if they resolve to private addresses.
+// Entries are host names, ".example.com" for all subdomains, IPs or CIDRs.
+var FetchAllowHosts []string
+
+// FetchDenyHosts are hosts that can never be fetched, in the same form as FetchAllowHosts.
+var FetchDenyHosts []string
+
+// FetchAllowPrivate allows fetching loopback, private and link-local addresses, they are blocked by default.
+var FetchAllowPrivate = false
+
+// ErrFetchBlocked is returned when a url is denied by the host lists or resolves to a private address.
+var ErrFetchBlocked = errors.New("fetch blocked")
+
+// Max redirects followed when fetching.
+const fetchMaxRedirects = 5
+
+// Carrier-grade NAT range, not covered by net.IP.IsPrivate
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// Check whether the host or ip matches any entry of the list.
+func matchHostList(list []string, host string, ip net.IP) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, entry := range list {
+		entry = strings.ToLower(entry)
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		} else if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+		} else if strings.HasPrefix(entry, ".") {
+			if strings.HasSuffix(host, entry) || host == entry[1:] {
+				return true
+			}
+		} else if host == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// Check a host and one of its addresses against the lists, ip may be nil before resolving.
+func checkFetchHost(host string, ip net.IP) error {
+	if matchHostList(FetchDenyHosts, host, ip) {
+		return fmt.Errorf("%w: %s is denied", ErrFetchBlocked, host)
+	}
+	if ip != nil && !FetchAllowPrivate && isPrivateIP(ip) && !matchHostList(FetchAllowHosts, host, ip) {
+		return fmt.Errorf("%w: %s resolves to private address %s", ErrFetchBlocked, host, ip)
+	}
+	return nil
+}
+
+// Check the url and every address its host resolves to.
+func checkFetchURL(ctx context.Context, target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %s", ErrFetchBlocked, target.Scheme)
+	}
+	host := target.Hostname()
+	if err := checkFetchHost(host, nil); err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return checkFetchHost(host, ip)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	// Hosts behind a proxy may only be resolvable by the proxy
+	if err != nil && FetchProxy != "" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if err = checkFetchHost(host, addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The shared fetch client, rebuilt when FetchProxy changes.
+var fetchClient = struct {
+	sync.Mutex
+	client *http.Client
+	proxy  string
+}{}
+
+// Address of a proxy url with its default port.
+func proxyAddress(proxy *url.URL) string {
+	port := proxy.Port()
+	if port == "" {
+		port = "80"
+		if proxy.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
+
+// Get the shared fetch client, connections are reused between fetches.
+func getFetchClient() (*http.Client, error) {
+	fetchClient.Lock()
+	defer fetchClient.Unlock()
+	if fetchClient.client != nil && fetchClient.proxy == FetchProxy {
+		return fetchClient.client, nil
+	}
+	client, err := newFetchClient(FetchProxy)
+	if err != nil {
+		return nil, err
+	}
+	if fetchClient.client != nil {
+		fetchClient.client.CloseIdleConnections()
+	}
+	fetchClient.client = client
+	fetchClient.proxy = FetchProxy
+	return client, nil
+}
+
+func newFetchClient(proxyURL string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	proxyFunc := http.ProxyFromEnvironment
+	if proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fetch proxy: %w", err)
+		}
+		proxyFunc = http.ProxyURL(proxy)
+	}
+	// Proxies are trusted even if they are private, so remember their exact addresses
+	var proxyMu sync.Mutex
+	proxyAddrs := map[string]bool{}
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		proxy, err := proxyFunc(req)
+		if proxy != nil {
+			proxyMu.Lock()
+			proxyAddrs[proxyAddress(proxy)] = true
+			proxyMu.Unlock()
+		}
+		return proxy, err
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	// Resolve and check the host again when connecting and dial the checked address,
+	// so that DNS rebinding cannot bypass the checks and allowed host names keep working
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		proxyMu.Lock()
+		isProxy := proxyAddrs[address]
+		proxyMu.Unlock()
+		if isProxy {
+			return dialer.DialContext(ctx, network, address)
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		ips := []net.IP{net.ParseIP(host)}
+		if ips[0] == nil {
+			addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			ips = ips[:0]
+			for _, addr := range addrs {
+				ips = append(ips, addr.IP)
+			}
+		}
+		for _, ip := range ips {
+			if err = checkFetchHost(host, ip); err != nil {
+				return nil, err
+			}
+		}
+		var conn net.Conn
+		for _, ip := range ips {
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= fetchMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", fetchMaxRedirects)
+			}
+			return checkFetchURL(req.Context(), req.URL)
+		},
+	}, nil
+}
+
+// Check that a base name can be used as a file name, names made only of dots such as ".." are rejected.
+func isSafeFileName(name string) bool {
+	return name != "/" && strings.Trim(name, ".") != ""
+}
+
+// Derive a file name from Content-Disposition, the url path or the content type.
+func fetchFileName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/")); isSafeFileName(name) {
+			return name
+		}
+	}
+	if name := path.Base(resp.Request.URL.Path); isSafeFileName(name) {
+		return name
+	}
+	name := "file"
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+			name += extensions[0]
+		}
+	}
+	return name
+}
+
+// Fetch outgoing media from a url with timeout, size limit and host checks.
+func fetchMedia(rawURL string) (*mediaData, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s: %w", rawURL, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), FetchTimeout)
+	defer cancel()
+	if err = checkFetchURL(ctx, target); err != nil {
+		return nil, err
+	}
+	client, err := getFetchClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unable to get %s: status %s", rawURL, resp.Status)
+	}
+	if FetchMaxBytes > 0 && resp.ContentLength > FetchMaxBytes {
+		return nil, fmt.Errorf("unable to get %s: %d bytes exceeds the limit of %d", rawURL, resp.ContentLength, FetchMaxBytes)
+	}
+	body := io.Reader(resp.Body)
+	if FetchMaxBytes > 0 {
+		body = io.LimitReader(resp.Body, FetchMaxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", rawURL, err)
+	}
+	if FetchMaxBytes > 0 && int64(len(data)) > FetchMaxBytes {
+		return nil, fmt.Errorf("unable to get %s: body exceeds the limit of %d bytes", rawURL, FetchMaxBytes)
+	}
+	return &mediaData{data: data, name: fetchFileName(resp)}, nil
+}
@@ -9,6 +9,7 @@ require github.com/gonebot-dev/gonebot v1.1.6
 require (
 	github.com/eatmoreapple/openwechat v1.4.8
 	github.com/rs/zerolog v1.33.0
+	golang.org/x/image v0.18.0
 )
 
 require (
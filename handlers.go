@@ -1,12 +1,11 @@
 package openwechat
 
 import (
-	"bytes"
 	"encoding/base64"
-	"image"
+	"errors"
+	"fmt"
 	"io"
-	"net/http"
-	"os"
+	"strconv"
 	"strings"
 
 	"github.com/eatmoreapple/openwechat"
@@ -17,32 +16,88 @@ import (
 
 type EmptyActionResult struct{}
 
+// Actions may take long, so each of them runs in its own goroutine.
 func actionHandler() {
 	for {
 		msg := OpenWechat.ActionChannel.Pull()
-		logging.Logf(zerolog.InfoLevel, "OpenWechat", "Ignored action call.")
-		*(msg.ResultChannel) <- EmptyActionResult{}
+		go func(call *message.ActionCall) {
+			switch action := call.Action.(type) {
+			case BroadcastAction:
+				*(call.ResultChannel) <- broadcast(action)
+			case *BroadcastAction:
+				*(call.ResultChannel) <- broadcast(*action)
+			case ScheduleAction:
+				*(call.ResultChannel) <- schedules.schedule(action)
+			case *ScheduleAction:
+				*(call.ResultChannel) <- schedules.schedule(*action)
+			case CancelScheduleAction:
+				*(call.ResultChannel) <- schedules.cancel(action.ID)
+			case *CancelScheduleAction:
+				*(call.ResultChannel) <- schedules.cancel(action.ID)
+			case ListScheduleAction, *ListScheduleAction:
+				*(call.ResultChannel) <- schedules.list()
+			default:
+				logging.Logf(zerolog.InfoLevel, "OpenWechat", "Ignored action call.")
+				*(call.ResultChannel) <- EmptyActionResult{}
+			}
+		}(msg)
 	}
 }
 
+// Transfer and red packet messages.
+func isPayMessage(msg *openwechat.Message) bool {
+	return msg.IsTransferAccounts() || msg.IsReceiveRedPacket() || msg.IsSendRedPacket() ||
+		(msg.IsMedia() && (msg.AppMsgType == openwechat.AppMsgTypeTransfers || msg.AppMsgType == openwechat.AppMsgTypeRedEnvelopes))
+}
+
 func receiveHandler(msg *openwechat.Message) {
 	formatMsg := message.NewMessage()
 	formatMsg.Group = ""
 	formatMsg.Self = Self.UserName
 	from := "friend"
-	if msg.IsSendByFriend() {
+	var group *openwechat.Group
+	var sender *openwechat.User
+	if msg.IsSendBySelf() {
+		// Messages sent by the adapter itself come back as well, never deliver them
+		if sentMessageIDs.Has(msg.MsgId) {
+			return
+		}
+		// Pay notices are records rather than chat, so transfers and red packets sent by self are always delivered
+		if !DeliverSelfMessages && !isPayMessage(msg) {
+			logging.Log(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Ignored self message.")
+			return
+		}
+		from = "self"
+		sender = Self.User
+		formatMsg.Sender = Self.UserName
+		if msg.IsSelfSendToGroup() {
+			from = "self group"
+			if groups, err := Self.Groups(); err == nil {
+				group = groups.SearchByUserName(1, msg.ToUserName).First()
+			}
+			formatMsg.Group = msg.ToUserName
+		} else {
+			formatMsg.Receiver = msg.ToUserName
+		}
+	} else if msg.IsSendByFriend() {
 		formatMsg.IsToMe = true
-		sender, _ := msg.Sender()
+		sender, _ = msg.Sender()
 		receiver, _ := msg.Receiver()
 		formatMsg.Sender = sender.UserName
 		formatMsg.Receiver = receiver.UserName
 	} else if msg.IsSendByGroup() {
 		from = "group"
-		groupUser, _ := msg.Sender()
-		sender, _ := msg.SenderInGroup()
-		group, _ := groupUser.AsGroup()
-		formatMsg.Group = group.UserName
-		formatMsg.Sender = sender.UserName
+		if groupUser, err := msg.Sender(); err == nil {
+			group, _ = groupUser.AsGroup()
+		}
+		if group != nil {
+			formatMsg.Group = group.UserName
+		}
+		// System messages in group have no sender
+		if groupSender, err := msg.SenderInGroup(); err == nil {
+			sender = groupSender
+			formatMsg.Sender = sender.UserName
+		}
 	}
 	if msg.IsText() {
 		msg.AsRead()
@@ -50,8 +105,28 @@ func receiveHandler(msg *openwechat.Message) {
 		if msg.IsAt() && msg.ToUserName == Self.UserName {
 			formatMsg.IsToMe = true
 		}
-		formatMsg.Text(msg.Content)
-	} else if msg.IsPicture() || msg.IsEmoticon() {
+		attachText(formatMsg, msg.Content)
+	} else if msg.IsEmoticon() {
+		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s emoticon message.", from)
+		msg.AsRead()
+		sticker := StickerType{}
+		if emoticon, err := parseEmoticon(msg.Content); err == nil {
+			sticker.MD5 = emoticon.Emoji.MD5
+			sticker.URL = emoticon.Emoji.CDNURL
+			sticker.Width = emoticon.Emoji.Width
+			sticker.Height = emoticon.Emoji.Height
+		} else {
+			logging.Logf(zerolog.WarnLevel, "OpenWechat", "receiveHandler: Parse emoticon error: %v", err)
+		}
+		if response, err := msg.GetPicture(); err == nil {
+			img, err := io.ReadAll(response.Body)
+			response.Body.Close()
+			if err == nil {
+				sticker.File = "base64://" + base64.StdEncoding.EncodeToString(img)
+			}
+		}
+		formatMsg.Any(sticker)
+	} else if msg.IsPicture() {
 		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s picture message.", from)
 		msg.AsRead()
 		response, err := msg.GetPicture()
@@ -66,11 +141,10 @@ func receiveHandler(msg *openwechat.Message) {
 		}
 		base64Encoding := "base64://" + base64.StdEncoding.EncodeToString(img)
 		formatMsg.Image(base64Encoding)
-	} else if msg.IsLocation() {
-		// Cannot get location info from location message
-		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s location message.", from)
+	} else if msg.IsLocation() || msg.MsgType == openwechat.MsgTypeLocation {
 		msg.AsRead()
-		formatMsg.Any(LocationType{})
+		formatMsg.Any(parseLocation(msg.Content, msg.Url))
+		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s location message: %s", from, LocationType{}.ToRawText(formatMsg.GetSegments()[0]))
 	} else if msg.IsRealtimeLocationStart() {
 		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s realtime location start message.", from)
 		msg.AsRead()
@@ -80,12 +154,25 @@ func receiveHandler(msg *openwechat.Message) {
 		msg.AsRead()
 		formatMsg.Any(RealtimeLocationStopType{})
 	} else if msg.IsVoice() {
-		// Just to base64
 		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s voice message.", from)
 		msg.AsRead()
-		response, _ := msg.GetVoice()
-		voi, _ := io.ReadAll(response.Body)
-		formatMsg.Voice(base64.StdEncoding.EncodeToString(voi))
+		response, err := msg.GetVoice()
+		if err != nil {
+			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "receiveHandler: Get voice error: %v", err)
+			return
+		}
+		voi, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "receiveHandler: Read voice error: %v", err)
+			return
+		}
+		voi, format := transcodeVoice(voi)
+		formatMsg.Voice("base64://" + base64.StdEncoding.EncodeToString(voi))
+		formatMsg.Any(VoiceInfoType{
+			Format:   format,
+			Duration: msg.VoiceLength,
+		})
 	} else if msg.IsFriendAdd() {
 		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s friend add message.", from)
 		addmsg, err := msg.FriendAddMessageContent()
@@ -93,24 +180,37 @@ func receiveHandler(msg *openwechat.Message) {
 			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "receiveHandler: Read friend add message error: %s", err.Error())
 			return
 		}
-		var sex string
-		if addmsg.Sex == 1 {
-			sex = "男"
-		} else if addmsg.Sex == 0 {
-			sex = "女"
-		} else {
-			sex = "未知"
-		}
-		formatMsg.Any(FriendAddType{
-			NickName: addmsg.FromNickName,
-			UserName: addmsg.FromUserName,
-			WechatID: addmsg.Alias,
-			Sex:      sex,
-			Country:  addmsg.Country,
-			Province: addmsg.Province,
-			City:     addmsg.City,
-			Msg:      msg,
-		})
+		friendAdd := FriendAddType{
+			NickName:      addmsg.FromNickName,
+			UserName:      addmsg.FromUserName,
+			WechatID:      addmsg.Alias,
+			Sex:           Gender(addmsg.Sex),
+			Country:       addmsg.Country,
+			Province:      addmsg.Province,
+			City:          addmsg.City,
+			Signature:     addmsg.Sign,
+			AvatarURL:     addmsg.BigHeadImgUrl,
+			VerifyContent: addmsg.Content,
+			Scene:         addmsg.Scene,
+			SceneName:     friendAddScene(addmsg.Scene),
+			Ticket:        addmsg.Ticket,
+			Msg:           msg,
+		}
+		if friendAdd.AvatarURL == "" {
+			friendAdd.AvatarURL = addmsg.SmallHeadImgUrl
+		}
+		if friendAdd.Ticket == "" {
+			friendAdd.Ticket = msg.RecommendInfo.Ticket
+		}
+		if keyword, ok := matchAutoAcceptKeyword(friendAdd.VerifyContent); ok {
+			if _, err := msg.Agree(); err != nil {
+				logging.Logf(zerolog.ErrorLevel, "OpenWechat", "receiveHandler: Auto accept friend %s error: %s", friendAdd.NickName, err.Error())
+			} else {
+				logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Auto accepted friend %s by keyword %s.", friendAdd.NickName, keyword)
+				friendAdd.AutoAccepted = true
+			}
+		}
+		formatMsg.Any(friendAdd)
 		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s friend add message: %s", from, FriendAddType{}.ToRawText(formatMsg.GetSegments()[0]))
 	} else if msg.IsCard() {
 		msg.AsRead()
@@ -119,22 +219,20 @@ func receiveHandler(msg *openwechat.Message) {
 			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "receiveHandler: Read %s card message error: %s", from, err.Error())
 			return
 		}
-		var sex string
-		if cardmsg.Sex == 1 {
-			sex = "男"
-		} else if cardmsg.Sex == 0 {
-			sex = "女"
-		} else {
-			sex = "未知"
-		}
-		formatMsg.Any(CardType{
-			NickName: cardmsg.NickName,
-			UserName: cardmsg.UserName,
-			Sex:      sex,
-			WechatID: cardmsg.Alias,
-			Province: cardmsg.Province,
-			City:     cardmsg.City,
-		})
+		card := CardType{
+			NickName:  cardmsg.NickName,
+			UserName:  cardmsg.UserName,
+			Sex:       Gender(cardmsg.Sex),
+			WechatID:  cardmsg.Alias,
+			Province:  cardmsg.Province,
+			City:      cardmsg.City,
+			Signature: cardmsg.Sign,
+			AvatarURL: cardmsg.BigHeadImgUrl,
+		}
+		if card.AvatarURL == "" {
+			card.AvatarURL = cardmsg.SmallHeadImgUrl
+		}
+		formatMsg.Any(card)
 		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s card message: %s", from, CardType{}.ToRawText(formatMsg.GetSegments()[0]))
 	} else if msg.IsVideo() {
 		// ? To fetch the video here is really a bad idea.
@@ -149,266 +247,282 @@ func receiveHandler(msg *openwechat.Message) {
 			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "receiveHandler: Read recalled message error: %s", err.Error())
 			return
 		}
-		formatMsg.Any(RecallType{
+		recall := RecallType{
 			Recaller:   formatMsg.Sender,
 			ReplaceMsg: revokemsg.RevokeMsg.ReplaceMsg,
-		})
+			MsgID:      strconv.FormatInt(revokemsg.RevokeMsg.MsgId, 10),
+		}
+		original, ok := recentMessages.Get(recall.MsgID)
+		if !ok {
+			original, ok = recentMessages.Get(strconv.FormatInt(revokemsg.RevokeMsg.OldMsgId, 10))
+		}
+		if ok {
+			recall.Sender = original.Sender
+			recall.Segments = original.GetSegments()
+		}
+		formatMsg.Any(recall)
 		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s recall message: %s", from, RecallType{}.ToRawText(formatMsg.GetSegments()[0]))
-	} else if msg.IsSystem() {
-		logging.Log(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Ignored system message.")
-		return
-	} else if msg.IsTransferAccounts() {
-		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s transfer accounts message.", from)
+	} else if msg.IsTransferAccounts() || (msg.IsMedia() && msg.AppMsgType == openwechat.AppMsgTypeTransfers) {
 		msg.AsRead()
-		formatMsg.Any(TransferType{})
-	} else if msg.IsReceiveRedPacket() {
-		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s receive red packet message.", from)
+		transfer := TransferType{Direction: "received"}
+		if msg.IsSendBySelf() {
+			transfer.Direction = "sent"
+		}
+		if paymsg, err := parseWCPay(msg.Content); err == nil {
+			transfer.Amount = parseFeeAmount(paymsg.AppMsg.WCPayInfo.FeeDesc)
+			transfer.FeeDesc = paymsg.AppMsg.WCPayInfo.FeeDesc
+			transfer.Memo = paymsg.AppMsg.WCPayInfo.PayMemo
+			transfer.TransferID = paymsg.AppMsg.WCPayInfo.TransferID
+			transfer.TransactionID = paymsg.AppMsg.WCPayInfo.TranscationID
+			transfer.Status = transferStatus(paymsg.AppMsg.WCPayInfo.PaySubType)
+			transfer.Description = paymsg.AppMsg.Des
+			transfer.BeginTime = paymsg.AppMsg.WCPayInfo.BeginTransferTime
+			transfer.InvalidTime = paymsg.AppMsg.WCPayInfo.InvalidTime
+		} else {
+			logging.Logf(zerolog.WarnLevel, "OpenWechat", "receiveHandler: Parse transfer message error: %s", err.Error())
+			transfer.Status = "unknown"
+		}
+		formatMsg.Any(transfer)
+		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s transfer accounts message: %s", from, TransferType{}.ToRawText(formatMsg.GetSegments()[0]))
+	} else if msg.IsReceiveRedPacket() || msg.IsSendRedPacket() {
 		msg.AsRead()
-		formatMsg.Any(RedPacketType{})
-	} else if msg.IsTickled() {
-		if msg.IsTickledMe() {
-			formatMsg.IsToMe = true
+		redPacket := RedPacketType{Direction: "received"}
+		if msg.IsSendRedPacket() {
+			redPacket.Direction = "sent"
 		}
-		formatMsg.Any(TickleType{
-			Msg: msg.Content,
-		})
-		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s tickle message: %s", from, TickleType{}.ToRawText(formatMsg.GetSegments()[0]))
-	} else if msg.IsJoinGroup() {
-		formatMsg.Any(JoinGroupType{})
-		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received join group message: %s", TickleType{}.ToRawText(formatMsg.GetSegments()[0]))
-	} else {
-		logging.Log(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Ignored unknown message.")
-		return
-	}
-	OpenWechat.ReceiveChannel.Push(*formatMsg, true)
-}
-
-func isURL(str string) bool {
-	return strings.HasPrefix(str, "http://") || strings.HasPrefix(str, "https://")
-}
-
-func isBase64Img(str string) bool {
-	return strings.HasPrefix(str, "base64://")
-}
-
-func sendImageToFriend(friendUserName string, img message.ImageType) {
-	friends, err := Self.Friends()
-	if err != nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendImageToFriend: Unable to get friends: %s", err.Error())
-		return
-	}
-	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendImageToFriend: Image to friend %s.", friendUserName)
-	friend := friends.SearchByUserName(1, friendUserName).First()
-	if friend == nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendImageToFriend: Friend %s not found.", friendUserName)
-		return
-	}
-	_, err = os.Stat(img.File)
-	if isURL(img.File) {
-		resp, err := http.Get(img.File)
-		if err != nil {
-			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendImageToFriend: Unable to get image from url: %s, Error: %s", img.File, err.Error())
-			return
+		formatMsg.Any(redPacket)
+		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s red packet message: %s", from, RedPacketType{}.ToRawText(formatMsg.GetSegments()[0]))
+	} else if msg.IsMedia() && msg.AppMsgType == openwechat.AppMsgTypeRedEnvelopes {
+		msg.AsRead()
+		redPacket := RedPacketType{Direction: "received"}
+		if msg.IsSendBySelf() {
+			redPacket.Direction = "sent"
 		}
-		friend.SendImage(resp.Body)
-		resp.Body.Close()
-	} else if isBase64Img(img.File) {
-		img.File = strings.ReplaceAll(img.File, "base64://", "")
-		imgData, err := base64.StdEncoding.DecodeString(img.File)
-		if err != nil {
-			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendImageToFriend: Unable to decode base64 image: %s", err.Error())
-			return
+		if paymsg, err := parseWCPay(msg.Content); err == nil {
+			redPacket.Memo = paymsg.AppMsg.WCPayInfo.ReceiverTitle
+			if redPacket.Direction == "sent" {
+				redPacket.Memo = paymsg.AppMsg.WCPayInfo.SenderTitle
+			}
+			redPacket.SceneText = paymsg.AppMsg.WCPayInfo.SceneText
+			redPacket.Description = paymsg.AppMsg.Des
+		} else {
+			logging.Logf(zerolog.WarnLevel, "OpenWechat", "receiveHandler: Parse red packet message error: %s", err.Error())
 		}
-		image.Decode(bytes.NewReader(imgData))
-		friend.SendImage(bytes.NewReader(imgData))
-	} else if err == nil {
-		imgData, _ := os.Open(img.File)
-		friend.SendImage(imgData)
-		imgData.Close()
-	} else {
-		logging.Log(zerolog.WarnLevel, "OpenWechat", "sendImageToFriend: Unknown image type.")
-	}
-}
-
-func sendImageToGroup(groupUserName string, img message.ImageType) {
-	groups, err := Self.Groups()
-	if err != nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendImageToGroup: Unable to get groups: %s", err.Error())
-		return
-	}
-	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendImageToFriend: Image to friend %s: %s", groupUserName)
-	group := groups.SearchByUserName(1, groupUserName).First()
-	if group == nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendImageToGroup: Group %s not found.", groupUserName)
-		return
-	}
-	_, err = os.Stat(img.File)
-	if isURL(img.File) {
-		resp, err := http.Get(img.File)
+		formatMsg.Any(redPacket)
+		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s red packet message: %s", from, RedPacketType{}.ToRawText(formatMsg.GetSegments()[0]))
+	} else if msg.IsMedia() {
+		msg.AsRead()
+		appmsg, err := parseAppMessage(msg.Content)
 		if err != nil {
-			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendImageToGroup: Unable to get image from url: %s, Error: %s", img.File, err.Error())
-			return
+			logging.Logf(zerolog.WarnLevel, "OpenWechat", "receiveHandler: Parse app message error: %s", err.Error())
+			appmsg = &appMessageContent{}
 		}
-		group.SendImage(resp.Body)
-		resp.Body.Close()
-	} else if isBase64Img(img.File) {
-		img.File = strings.ReplaceAll(img.File, "base64://", "")
-		imgData, err := base64.StdEncoding.DecodeString(img.File)
-		if err != nil {
-			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendImageToGroup: Unable to decode base64 image: %s", err.Error())
+		switch msg.AppMsgType {
+		case appMsgTypeMusic:
+			formatMsg.Any(MusicType{
+				Title:   appmsg.AppMsg.Title,
+				Singer:  appmsg.AppMsg.Des,
+				URL:     appmsg.AppMsg.URL,
+				DataURL: appmsg.AppMsg.DataURL,
+				Cover:   appmsg.AppMsg.SongAlbumURL,
+				AppName: appmsg.AppInfo.AppName,
+			})
+		case appMsgTypeMiniProgram, appMsgTypeMiniProgramPage:
+			cover := appmsg.AppMsg.ThumbURL
+			if cover == "" {
+				cover = appmsg.AppMsg.WeAppInfo.WeAppIconURL
+			}
+			formatMsg.Any(MiniProgramType{
+				Title:    appmsg.AppMsg.Title,
+				AppName:  appmsg.AppMsg.SourceDisplayName,
+				AppID:    appmsg.AppMsg.WeAppInfo.AppID,
+				UserName: appmsg.AppMsg.WeAppInfo.UserName,
+				PagePath: appmsg.AppMsg.WeAppInfo.PagePath,
+				Cover:    cover,
+				URL:      appmsg.AppMsg.URL,
+			})
+		case appMsgTypeChannels:
+			channel := ChannelType{
+				Title:    appmsg.AppMsg.Title,
+				Desc:     appmsg.AppMsg.FinderFeed.Desc,
+				NickName: appmsg.AppMsg.FinderFeed.NickName,
+				ObjectID: appmsg.AppMsg.FinderFeed.ObjectID,
+				URL:      appmsg.AppMsg.URL,
+			}
+			if len(appmsg.AppMsg.FinderFeed.MediaList) > 0 {
+				media := appmsg.AppMsg.FinderFeed.MediaList[0]
+				channel.Cover = media.CoverURL
+				if channel.Cover == "" {
+					channel.Cover = media.ThumbURL
+				}
+				if channel.URL == "" {
+					channel.URL = media.URL
+				}
+			}
+			formatMsg.Any(channel)
+		default:
+			formatMsg.Any(AppMessageType{
+				AppMsgType: int(msg.AppMsgType),
+				Title:      appmsg.AppMsg.Title,
+				Des:        appmsg.AppMsg.Des,
+				URL:        appmsg.AppMsg.URL,
+				Content:    msg.Content,
+			})
+		}
+		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s app message: %s", from, formatMsg.GetSegments()[0].Data.ToRawText(formatMsg.GetSegments()[0]))
+	} else if msg.IsTickled() || (msg.IsSystem() && isTickleContent(msg.Content)) {
+		var members openwechat.Members
+		if group != nil {
+			members, _ = group.Members()
+		} else if friends, err := Self.Friends(); err == nil {
+			peer := msg.FromUserName
+			if msg.IsSendBySelf() {
+				peer = msg.ToUserName
+			}
+			members = friends.SearchByUserName(1, peer).AsMembers()
+		}
+		tickle, ok := parseTickle(msg.Content, members)
+		if !ok {
+			logging.Logf(zerolog.WarnLevel, "OpenWechat", "receiveHandler: Unable to parse tickle message: %s", msg.Content)
+		}
+		if msg.IsTickledMe() || (tickle.Target != "" && tickle.Target == Self.UserName) {
+			formatMsg.IsToMe = true
+		}
+		// Tickle messages in group have no sender, the actor is the sender
+		if formatMsg.Sender == "" {
+			formatMsg.Sender = tickle.Actor
+		}
+		formatMsg.Any(tickle)
+		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s tickle message: %s", from, TickleType{}.ToRawText(formatMsg.GetSegments()[0]))
+	} else if msg.IsSystem() && group != nil {
+		notice := parseGroupNotice(msg.Content, group)
+		if notice == nil {
+			logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Ignored group system message: %s", msg.Content)
 			return
 		}
-		group.SendImage(bytes.NewReader(imgData))
-	} else if err == nil {
-		imgData, _ := os.Open(img.File)
-		group.SendImage(imgData)
-		imgData.Close()
-	} else {
-		logging.Log(zerolog.WarnLevel, "OpenWechat", "sendImageToGroup: Unknown image type.")
-	}
-}
-
-func sendFileToFriend(friendUserName string, f message.FileType) {
-	friends, err := Self.Friends()
-	if err != nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendFileToFriend: Unable to get friends: %s", err.Error())
-		return
-	}
-	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendFileToFriend: File to friend %s.", friendUserName)
-	friend := friends.SearchByUserName(1, friendUserName).First()
-	if friend == nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendFileToFriend: Friend %s not found.", friendUserName)
+		formatMsg.Any(notice)
+		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received group notice message: %s", notice.ToRawText(formatMsg.GetSegments()[0]))
+	} else if msg.IsSystem() {
+		logging.Log(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Ignored system message.")
 		return
-	}
-	_, err = os.Stat(f.File)
-	if isURL(f.File) {
-		resp, err := http.Get(f.File)
-		if err != nil {
-			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendFileToFriend: Unable to get file from url: %s, Error: %s", f.File, err.Error())
+	} else {
+		countUnknownMessage(int(msg.MsgType), int(msg.AppMsgType))
+		if !DeliverUnknownMessages {
+			logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Ignored unknown message, MsgType: %d, AppMsgType: %d.", msg.MsgType, msg.AppMsgType)
 			return
 		}
-		friend.SendFile(resp.Body)
-		resp.Body.Close()
-	} else if err == nil {
-		fileData, _ := os.Open(f.File)
-		friend.SendFile(fileData)
-		fileData.Close()
-	} else {
-		logging.Logf(zerolog.WarnLevel, "OpenWechat", "sendFileToFriend: Unknown file type: %s", f.File)
+		msg.AsRead()
+		formatMsg.Any(RawType{
+			MsgType:    int(msg.MsgType),
+			AppMsgType: int(msg.AppMsgType),
+			Content:    msg.Content,
+		})
+		logging.Logf(zerolog.InfoLevel, "OpenWechat", "receiveHandler: Received %s unknown message: %s", from, RawType{}.ToRawText(formatMsg.GetSegments()[0]))
+	}
+	formatMsg.Any(MetadataType{
+		MsgID:      msg.MsgId,
+		NewMsgID:   strconv.FormatInt(msg.NewMsgId, 10),
+		CreateTime: msg.CreateTime,
+		MsgType:    int(msg.MsgType),
+		AppMsgType: int(msg.AppMsgType),
+		SenderName: displayName(sender),
+		Sender:     senderProfile(sender),
+		IsSelf:     msg.IsSendBySelf(),
+	})
+	if !msg.IsRecalled() {
+		recentMessages.Put(msg.MsgId, *formatMsg)
 	}
+	OpenWechat.ReceiveChannel.Push(*formatMsg, true)
 }
 
-func sendFileToGroup(groupUserName string, f message.FileType) {
-	groups, err := Self.Groups()
-	if err != nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendFileToGroup: Unable to get groups: %s", err.Error())
-		return
+// Get the name of a user as shown in chat, prefers group display name, then remark name and nickname.
+func displayName(user *openwechat.User) string {
+	if user == nil {
+		return ""
 	}
-	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendFileToGroup: File to friend %s.", groupUserName)
-	group := groups.SearchByUserName(1, groupUserName).First()
-	if group == nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendFileToGroup: Group %s not found.", groupUserName)
-		return
-	}
-	_, err = os.Stat(f.File)
-	if isURL(f.File) {
-		resp, err := http.Get(f.File)
-		if err != nil {
-			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendFileToGroup: Unable to get file from url: %s, Error: %s", f.File, err.Error())
-			return
+	for _, name := range []string{user.DisplayName, user.RemarkName, user.NickName} {
+		if name != "" {
+			return name
 		}
-		group.SendFile(resp.Body)
-		resp.Body.Close()
-	} else if err == nil {
-		fileData, _ := os.Open(f.File)
-		group.SendFile(fileData)
-		fileData.Close()
-	} else {
-		logging.Logf(zerolog.WarnLevel, "OpenWechat", "sendFileToGroup: Unknown file type: %s", f.File)
 	}
+	return ""
 }
 
-func sendTextToFriend(friendUserName string, text string) {
-	friends, err := Self.Friends()
-	if err != nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendTextToFriend: Unable to get friends: %s", err.Error())
-		return
-	}
-	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendTextToFriend: Text to friend %s: %s", friendUserName, text)
-	friend := friends.SearchByUserName(1, friendUserName).First()
-	if friend == nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendTextToFriend: Friend %s not found.", friendUserName)
-		return
+// Check if the verify content of a friend add message contains any of AutoAcceptKeywords.
+func matchAutoAcceptKeyword(verifyContent string) (string, bool) {
+	for _, keyword := range AutoAcceptKeywords {
+		if keyword != "" && strings.Contains(verifyContent, keyword) {
+			return keyword, true
+		}
 	}
-	friend.SendText(text)
+	return "", false
 }
 
-func sendTextToGroup(groupUserName string, text string) {
-	groups, err := Self.Groups()
-	if err != nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendTextToGroup: Unable to get groups: %s", err.Error())
-		return
+// Build the profile of a message sender from the contact cache.
+func senderProfile(sender *openwechat.User) SenderProfile {
+	if sender == nil {
+		return SenderProfile{}
 	}
-	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendTextToGroup: Text to group %s: %s", groupUserName, text)
-	group := groups.SearchByUserName(1, groupUserName).First()
-	if group == nil {
-		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendTextToGroup: Group %s not found.", groupUserName)
-		return
+	profile := SenderProfile{
+		UserName:    sender.UserName,
+		DisplayName: sender.DisplayName,
+		NickName:    sender.NickName,
+		RemarkName:  sender.RemarkName,
+		AvatarURL:   sender.HeadImgUrl,
+		AvatarID:    sender.AvatarID(),
 	}
-	group.SendText(text)
-}
-
-func sendImage(receiver, group string, img message.ImageType) {
-	if group == "" {
-		sendImageToFriend(receiver, img)
-	} else {
-		sendImageToGroup(group, img)
+	// Group members do not carry remark names, try the cached friend instead
+	if profile.RemarkName == "" {
+		if friends, err := Self.Friends(); err == nil {
+			if friend := friends.SearchByUserName(1, sender.UserName).First(); friend != nil {
+				profile.RemarkName = friend.RemarkName
+			}
+		}
 	}
+	return profile
 }
 
-func sendFile(receiver, group string, f message.FileType) {
-	if group == "" {
-		sendFileToFriend(receiver, f)
-	} else {
-		sendFileToGroup(group, f)
+func sendHandler() {
+	for {
+		sendQueue.push(OpenWechat.SendChannel.Pull(), nil)
 	}
 }
 
-func sendText(receiver, group string, text string) {
-	if group == "" {
-		sendTextToFriend(receiver, text)
-	} else {
-		sendTextToGroup(group, text)
+// Send all segments of the message in order with their renderers, inline segments next to each other are merged into one text.
+func sendMessage(msg message.Message) error {
+	var errs []error
+	text := ""
+	hasText := false
+	flushText := func() {
+		if hasText {
+			errs = append(errs, sendText(msg.Receiver, msg.Group, text))
+			text = ""
+			hasText = false
+		}
 	}
-}
-
-func sendHandler() {
-	for {
-		msg := OpenWechat.SendChannel.Pull()
-		text := ""
-		hasText := false
-		for _, segment := range msg.GetSegments() {
-			if segment.Type == "image" {
-				if hasText {
-					sendText(msg.Receiver, msg.Group, text)
-					text = ""
-					hasText = false
-				}
-				sendImage(msg.Receiver, msg.Group, segment.Data.(message.ImageType))
-			} else if segment.Type == "file" {
-				if hasText {
-					sendText(msg.Receiver, msg.Group, text)
-					text = ""
-					hasText = false
-				}
-				sendFile(msg.Receiver, msg.Group, segment.Data.(message.FileType))
-			} else if segment.Type == "text" {
+	for _, segment := range msg.GetSegments() {
+		renderer, ok := getSegmentRenderer(segment.Type)
+		if !ok || (renderer.Inline == nil && renderer.Send == nil) {
+			logging.Logf(zerolog.WarnLevel, "OpenWechat", "sendMessage: Unsupported segment type: %s", segment.Type)
+			errs = append(errs, fmt.Errorf("%w: %s", ErrUnsupportedSegment, segment.Type))
+			continue
+		}
+		if renderer.Inline != nil {
+			rendered, err := renderer.Inline(segment)
+			if err != nil {
+				logging.Logf(zerolog.WarnLevel, "OpenWechat", "sendMessage: Unable to render %s segment: %s", segment.Type, err.Error())
+				errs = append(errs, err)
+				continue
+			}
+			if rendered != "" {
 				hasText = true
-				text += segment.Data.(message.TextType).Text
+				text += rendered
 			}
+			continue
 		}
-		if hasText {
-			sendText(msg.Receiver, msg.Group, text)
-		}
+		flushText()
+		errs = append(errs, renderer.Send(msg.Receiver, msg.Group, segment))
 	}
+	flushText()
+	return errors.Join(errs...)
 }
@@ -0,0 +1,186 @@
+package openwechat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"github.com/gonebot-dev/gonebot/logging"
+	"github.com/rs/zerolog"
+	xdraw "golang.org/x/image/draw"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// ImageMaxDimension is the max width and height of outgoing images, larger ones are downscaled,
+// set it to 0 to disable downscaling. Animated GIFs are never downscaled.
+var ImageMaxDimension = 4096
+
+// ImageMaxBytes is the max size of outgoing images, larger ones are re-encoded as smaller JPEGs,
+// set it to 0 to disable the limit. Animated GIFs are never re-encoded.
+var ImageMaxBytes = 10 << 20
+
+// Images with more pixels are rejected before decoding, a small file can declare a huge canvas.
+const imageMaxPixels = 40_000_000
+
+// JPEG quality used when images are re-encoded.
+const imageJPEGQuality = 90
+
+// ErrNotImage is returned when an outgoing image segment does not contain an image.
+var ErrNotImage = errors.New("not an image")
+
+// Image formats WeChat accepts as they are, others are converted.
+var nativeImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Check that the media is an image, convert unsupported formats and downscale oversized images.
+func prepareImage(media *mediaData) (*mediaData, error) {
+	data, err := media.bytes()
+	if err != nil {
+		return nil, err
+	}
+	mimeType := http.DetectContentType(data)
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotImage, mimeType)
+	}
+	if int64(config.Width)*int64(config.Height) > imageMaxPixels {
+		return nil, fmt.Errorf("image of %dx%d is too large", config.Width, config.Height)
+	}
+	native := nativeImageTypes[mimeType]
+	oversized := ImageMaxDimension > 0 && max(config.Width, config.Height) > ImageMaxDimension
+	tooLarge := ImageMaxBytes > 0 && len(data) > ImageMaxBytes
+	if native && !oversized && !tooLarge {
+		return media, nil
+	}
+	if format == "gif" {
+		if frames, err := gifFrameCount(data); err == nil && frames > 1 {
+			return media, nil
+		}
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s image: %w", format, err)
+	}
+	if oversized {
+		img = scaleImage(img, float64(ImageMaxDimension)/float64(max(config.Width, config.Height)))
+	}
+	converted, err := encodeImage(img)
+	if err != nil {
+		return nil, err
+	}
+	// Keep shrinking until the image fits
+	for ImageMaxBytes > 0 && len(converted) > ImageMaxBytes {
+		bounds := img.Bounds()
+		if bounds.Dx() < 64 || bounds.Dy() < 64 {
+			return nil, fmt.Errorf("unable to shrink image below %d bytes", ImageMaxBytes)
+		}
+		img = scaleImage(img, 0.75)
+		if converted, err = encodeJPEG(img); err != nil {
+			return nil, err
+		}
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "prepareImage: Converted %s image %dx%d (%d bytes) to %dx%d (%d bytes).",
+		format, config.Width, config.Height, len(data), img.Bounds().Dx(), img.Bounds().Dy(), len(converted))
+	return &mediaData{data: converted}, nil
+}
+
+// Count the frames of a GIF by walking its blocks, decoding every frame of a small file
+// can allocate far more memory than the pixel limit allows.
+func gifFrameCount(data []byte) (int, error) {
+	errMalformed := errors.New("malformed gif")
+	// Size of a color table from the packed byte of a descriptor
+	colorTable := func(packed byte) int {
+		if packed&0x80 == 0 {
+			return 0
+		}
+		return 3 << (packed&0x07 + 1)
+	}
+	// Skip data sub-blocks, each led by its size and ended by an empty one
+	skipSubBlocks := func(pos int) (int, error) {
+		for pos < len(data) {
+			size := int(data[pos])
+			pos += size + 1
+			if size == 0 {
+				return pos, nil
+			}
+		}
+		return 0, errMalformed
+	}
+	if len(data) < 13 {
+		return 0, errMalformed
+	}
+	pos := 13 + colorTable(data[10])
+	frames := 0
+	for pos < len(data) {
+		var err error
+		switch data[pos] {
+		case 0x21:
+			// Extension: introducer, label and sub-blocks
+			if pos, err = skipSubBlocks(pos + 2); err != nil {
+				return 0, err
+			}
+		case 0x2c:
+			// Image: separator, descriptor, color table, LZW code size and sub-blocks
+			if pos+10 > len(data) {
+				return 0, errMalformed
+			}
+			frames++
+			if pos, err = skipSubBlocks(pos + 10 + colorTable(data[pos+9]) + 1); err != nil {
+				return 0, err
+			}
+		case 0x3b:
+			return frames, nil
+		default:
+			return 0, errMalformed
+		}
+	}
+	return frames, nil
+}
+
+// Scale the image by the factor.
+func scaleImage(img image.Image, factor float64) image.Image {
+	bounds := img.Bounds()
+	width := max(int(float64(bounds.Dx())*factor), 1)
+	height := max(int(float64(bounds.Dy())*factor), 1)
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
+
+// Encode opaque images as JPEG and transparent ones as PNG.
+func encodeImage(img image.Image) ([]byte, error) {
+	if isOpaque(img) {
+		return encodeJPEG(img)
+	}
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, fmt.Errorf("unable to encode png: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: imageJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("unable to encode jpeg: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func isOpaque(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return opaque.Opaque()
+	}
+	return false
+}
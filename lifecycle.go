@@ -80,6 +80,8 @@ func start() {
 	logging.Logf(zerolog.InfoLevel, "OpenWechat", "Login successful!")
 	Self, _ = bot.GetCurrentUser()
 
+	// Restore scheduled messages before actions can schedule new ones
+	schedules.load()
 	go sendHandler()
 	go actionHandler()
 
@@ -92,5 +94,10 @@ func finalize() {
 		Self.Bot().Logout()
 	}
 	Self = nil
+	sendQueue.Clear()
+	recentMessages.Clear()
+	sentMessageIDs.Clear()
+	sendLimiter.Clear()
+	schedules.Clear()
 	logging.Log(zerolog.InfoLevel, "OpenWechat", "Shutdown complete!")
 }
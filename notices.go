@@ -0,0 +1,238 @@
+package openwechat
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strings"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/gonebot-dev/gonebot/message"
+)
+
+type groupNoticePattern struct {
+	// Which group event the pattern matches, see parseGroupNotice
+	event  string
+	regexp *regexp.Regexp
+}
+
+// Group system message patterns, named groups are "operator", "members" and "name".
+var groupNoticePatterns = []groupNoticePattern{
+	// zh_CN
+	{"join_qrcode", regexp.MustCompile(`^["“]?(?P<members>[^"“”]+?)["”]?通过扫描["“]?(?P<operator>[^"“”]+?)["”]?分享的二维码加入群聊`)},
+	{"join_invite", regexp.MustCompile(`^["“]?(?P<operator>[^"“”]+?)["”]?邀请["“]?(?P<members>[^"“”]+?)["”]?加入了群聊`)},
+	{"kick", regexp.MustCompile(`^["“]?(?P<operator>[^"“”]+?)["”]?将["“]?(?P<members>[^"“”]+?)["”]?移出了群聊`)},
+	{"kick", regexp.MustCompile(`^(?P<members>你)被["“]?(?P<operator>[^"“”]+?)["”]?移出群聊`)},
+	{"leave", regexp.MustCompile(`^["“]?(?P<members>[^"“”]+?)["”]?已?退出了群聊`)},
+	{"rename", regexp.MustCompile(`^["“]?(?P<operator>[^"“”]+?)["”]?修改群名为["“](?P<name>.*)["”]$`)},
+	{"owner", regexp.MustCompile(`^["“]?(?P<members>[^"“”]+?)["”]?已成为新群主`)},
+	{"announcement", regexp.MustCompile(`^["“]?(?P<operator>[^"“”]+?)["”]?修改了群公告`)},
+	// en_US
+	{"join_qrcode", regexp.MustCompile(`^"?(?P<members>[^"]+?)"? joined the group chat via the QR [Cc]ode shared by "?(?P<operator>[^"]+?)"?\.?$`)},
+	{"join_invite", regexp.MustCompile(`^"?(?P<operator>[^"]+?)"? invited "?(?P<members>[^"]+?)"? to (join )?the group chat`)},
+	{"kick", regexp.MustCompile(`^"?(?P<operator>[^"]+?)"? removed "?(?P<members>[^"]+?)"? from the group chat`)},
+	{"kick", regexp.MustCompile(`^(?P<members>You) were removed from the group chat by "?(?P<operator>[^"]+?)"?\.?$`)},
+	{"leave", regexp.MustCompile(`^"?(?P<members>[^"]+?)"? (has )?left the group chat`)},
+	{"rename", regexp.MustCompile(`^"?(?P<operator>[^"]+?)"? changed the group name to "(?P<name>.*)"\.?$`)},
+	{"owner", regexp.MustCompile(`^"?(?P<members>[^"]+?)"? (is now|has become) the (new )?group owner`)},
+	{"announcement", regexp.MustCompile(`^"?(?P<operator>[^"]+?)"? (changed|updated) the group (announcement|notice)`)},
+}
+
+var noticeNameSeparatorRegexp = regexp.MustCompile(`、|，|, `)
+
+// Tickle message patterns, named groups are "actor", "target" and "suffix".
+var ticklePatterns = []*regexp.Regexp{
+	// zh_CN
+	regexp.MustCompile(`^(?:["“](?P<actor>[^"“”]+)["”]|(?P<actor>我|你))\s*拍了?拍\s*(?:["“](?P<target>[^"“”]+)["”]|(?P<target>我|你|自己))\s*(?P<suffix>.*)$`),
+	// en_US
+	regexp.MustCompile(`^(?:"(?P<actor>[^"]+)"|(?P<actor>I|You))\s+patted\s+(?:"(?P<target>[^"]+)"|(?P<target>me|you|myself|himself|herself|themselves))\s*(?P<suffix>.*)$`),
+}
+
+// System message template, some group system messages come in this form
+type sysMsgTemplate struct {
+	XMLName  xml.Name `xml:"sysmsg"`
+	Template struct {
+		Content struct {
+			Template string `xml:"template"`
+			Links    []struct {
+				Name      string `xml:"name,attr"`
+				Separator string `xml:"separator"`
+				Members   []struct {
+					NickName string `xml:"nickname"`
+				} `xml:"memberlist>member"`
+			} `xml:"link_list>link"`
+		} `xml:"content_template"`
+	} `xml:"sysmsgtemplate"`
+}
+
+// Convert a system message template into plain text like `"张三"邀请"李四"加入了群聊`.
+func sysMsgTemplateText(content string) string {
+	if index := strings.Index(content, "<sysmsg"); index >= 0 {
+		content = content[index:]
+	} else {
+		return content
+	}
+	var tmpl sysMsgTemplate
+	if err := xml.Unmarshal([]byte(content), &tmpl); err != nil {
+		return content
+	}
+	text := tmpl.Template.Content.Template
+	for _, link := range tmpl.Template.Content.Links {
+		separator := link.Separator
+		if separator == "" {
+			separator = "、"
+		}
+		names := make([]string, 0, len(link.Members))
+		for _, member := range link.Members {
+			names = append(names, member.NickName)
+		}
+		text = strings.ReplaceAll(text, "$"+link.Name+"$", strings.Join(names, separator))
+	}
+	return text
+}
+
+// Split names like "张三、李四" into separate names.
+func splitNoticeNames(names string) []string {
+	result := make([]string, 0)
+	for _, name := range noticeNameSeparatorRegexp.Split(names, -1) {
+		if name = strings.TrimSpace(name); name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// Resolve a name in a group notice to UserName, returns empty string if not found.
+func resolveNoticeName(name string, members openwechat.Members) string {
+	if name == "你" || name == "我" || name == "You" || name == "you" || name == "I" || name == "me" {
+		return Self.UserName
+	}
+	for _, search := range []func(user *openwechat.User) bool{
+		func(user *openwechat.User) bool { return user.DisplayName == name },
+		func(user *openwechat.User) bool { return user.NickName == name },
+		func(user *openwechat.User) bool { return user.RemarkName == name },
+	} {
+		if user := members.Search(1, search).First(); user != nil {
+			return user.UserName
+		}
+	}
+	return ""
+}
+
+func resolveNoticeNames(names []string, members openwechat.Members) []string {
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		result = append(result, resolveNoticeName(name, members))
+	}
+	return result
+}
+
+// Parse a group system message into a group notice segment, returns nil if it is not a known group notice.
+func parseGroupNotice(content string, group *openwechat.Group) message.MessageType {
+	text := strings.TrimSpace(sysMsgTemplateText(content))
+	for _, pattern := range groupNoticePatterns {
+		match := pattern.regexp.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+		fields := make(map[string]string)
+		for i, name := range pattern.regexp.SubexpNames() {
+			if name != "" {
+				fields[name] = match[i]
+			}
+		}
+		var members openwechat.Members
+		if group != nil {
+			members, _ = group.Members()
+		}
+		memberNames := splitNoticeNames(fields["members"])
+		switch pattern.event {
+		case "join_invite", "join_qrcode":
+			via := "invite"
+			if pattern.event == "join_qrcode" {
+				via = "qrcode"
+			}
+			return JoinGroupType{
+				Inviter:     resolveNoticeName(fields["operator"], members),
+				InviterName: fields["operator"],
+				Members:     resolveNoticeNames(memberNames, members),
+				MemberNames: memberNames,
+				Via:         via,
+			}
+		case "kick":
+			return KickGroupType{
+				Operator:     resolveNoticeName(fields["operator"], members),
+				OperatorName: fields["operator"],
+				Members:      resolveNoticeNames(memberNames, members),
+				MemberNames:  memberNames,
+			}
+		case "leave":
+			return LeaveGroupType{
+				Members:     resolveNoticeNames(memberNames, members),
+				MemberNames: memberNames,
+			}
+		case "rename":
+			return RenameGroupType{
+				Operator:     resolveNoticeName(fields["operator"], members),
+				OperatorName: fields["operator"],
+				Name:         fields["name"],
+			}
+		case "owner":
+			return GroupOwnerType{
+				Owner:     resolveNoticeName(fields["members"], members),
+				OwnerName: fields["members"],
+			}
+		case "announcement":
+			return GroupAnnouncementType{
+				Operator:     resolveNoticeName(fields["operator"], members),
+				OperatorName: fields["operator"],
+			}
+		}
+	}
+	return nil
+}
+
+// Check whether the content of a system message is a tickle in any supported language,
+// openwechat only recognizes the Chinese ones.
+func isTickleContent(content string) bool {
+	content = strings.TrimSpace(content)
+	for _, pattern := range ticklePatterns {
+		if pattern.MatchString(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse a tickle message, names are resolved with members, returns false if the content is not recognized.
+func parseTickle(content string, members openwechat.Members) (TickleType, bool) {
+	result := TickleType{Msg: content}
+	for _, pattern := range ticklePatterns {
+		match := pattern.FindStringSubmatch(strings.TrimSpace(content))
+		if match == nil {
+			continue
+		}
+		// Alternative groups share the same name, keep the one that matched
+		for i, name := range pattern.SubexpNames() {
+			if match[i] == "" {
+				continue
+			}
+			switch name {
+			case "actor":
+				result.ActorName = match[i]
+			case "target":
+				result.TargetName = match[i]
+			case "suffix":
+				result.Suffix = match[i]
+			}
+		}
+		result.Actor = resolveNoticeName(result.ActorName, members)
+		switch result.TargetName {
+		case "自己", "myself", "himself", "herself", "themselves":
+			result.Target = result.Actor
+		default:
+			result.Target = resolveNoticeName(result.TargetName, members)
+		}
+		return result, true
+	}
+	return result, false
+}
@@ -9,6 +9,18 @@ var OpenWechat adapter.Adapter
 var Self *openwechat.Self
 var Emoji = openwechat.Emoji
 
+// DeliverSelfMessages controls whether messages sent by the logged-in account from other devices are delivered,
+// they are dropped by default. Delivered self messages have Sender set to Self and IsSelf set in their metadata.
+var DeliverSelfMessages = false
+
+// DeliverUnknownMessages controls whether messages of unknown types are delivered as raw segments,
+// they are dropped by default. Unknown types are counted either way, see UnknownMessageCounts.
+var DeliverUnknownMessages = false
+
+// AutoAcceptKeywords makes friend requests whose verify content contains any of the keywords accepted automatically,
+// leave it empty to disable auto accepting.
+var AutoAcceptKeywords []string
+
 func init() {
 	OpenWechat.Name = "OpenWechat"
 	OpenWechat.Version = "v0.1.2"
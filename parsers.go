@@ -0,0 +1,219 @@
+package openwechat
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Emoticon message content
+type emoticonContent struct {
+	XMLName xml.Name `xml:"msg"`
+	Emoji   struct {
+		MD5    string `xml:"md5,attr"`
+		CDNURL string `xml:"cdnurl,attr"`
+		Width  int    `xml:"width,attr"`
+		Height int    `xml:"height,attr"`
+	} `xml:"emoji"`
+}
+
+// Parse the emoticon xml from the content of an emoticon message.
+func parseEmoticon(content string) (*emoticonContent, error) {
+	var result emoticonContent
+	if index := strings.Index(content, "<msg>"); index > 0 {
+		content = content[index:]
+	}
+	err := xml.Unmarshal([]byte(content), &result)
+	return &result, err
+}
+
+// Location message content, only available in the legacy location message type
+type locationContent struct {
+	XMLName  xml.Name `xml:"msg"`
+	Location struct {
+		X       float64 `xml:"x,attr"`
+		Y       float64 `xml:"y,attr"`
+		Label   string  `xml:"label,attr"`
+		POIName string  `xml:"poiname,attr"`
+	} `xml:"location"`
+}
+
+// Parse the location from the content and map url of a location message.
+//
+// The content looks like "label:\n/cgi-bin/mmwebwx-bin/webwxgetpubliclinkimg?...&pictype=location",
+// and the url looks like "https://apis.map.qq.com/uri/v1/geocoder?coord=39.9,116.3".
+func parseLocation(content, mapURL string) LocationType {
+	result := LocationType{URL: mapURL}
+	if index := strings.Index(content, "<msg>"); index >= 0 {
+		var loc locationContent
+		if err := xml.Unmarshal([]byte(content[index:]), &loc); err == nil {
+			result.Latitude = loc.Location.X
+			result.Longitude = loc.Location.Y
+			result.Label = loc.Location.Label
+			result.POIName = loc.Location.POIName
+		}
+	} else if index := strings.Index(content, ":\n"); index >= 0 {
+		result.Label = content[:index]
+	}
+	if u, err := url.Parse(mapURL); err == nil {
+		coord := strings.Split(u.Query().Get("coord"), ",")
+		if len(coord) == 2 {
+			lat, err1 := strconv.ParseFloat(strings.TrimSpace(coord[0]), 64)
+			lon, err2 := strconv.ParseFloat(strings.TrimSpace(coord[1]), 64)
+			if err1 == nil && err2 == nil {
+				result.Latitude = lat
+				result.Longitude = lon
+			}
+		}
+	}
+	// Location labels are often "POI name" or "address(POI name)"
+	if result.POIName == "" {
+		if start, end := strings.LastIndex(result.Label, "("), strings.LastIndex(result.Label, ")"); start >= 0 && end == len(result.Label)-1 {
+			result.POIName = result.Label[start+1 : end]
+			result.Label = result.Label[:start]
+		}
+	}
+	return result
+}
+
+// WeChat pay info inside transfer and red packet app messages
+type wcPayContent struct {
+	XMLName xml.Name `xml:"msg"`
+	AppMsg  struct {
+		Title     string `xml:"title"`
+		Des       string `xml:"des"`
+		WCPayInfo struct {
+			PaySubType        int    `xml:"paysubtype"`
+			FeeDesc           string `xml:"feedesc"`
+			TranscationID     string `xml:"transcationid"`
+			TransferID        string `xml:"transferid"`
+			InvalidTime       int64  `xml:"invalidtime"`
+			BeginTransferTime int64  `xml:"begintransfertime"`
+			PayMemo           string `xml:"pay_memo"`
+			ReceiverTitle     string `xml:"receivertitle"`
+			SenderTitle       string `xml:"sendertitle"`
+			SceneText         string `xml:"scenetext"`
+		} `xml:"wcpayinfo"`
+	} `xml:"appmsg"`
+}
+
+// Parse the wechat pay info from the content of a transfer or red packet message.
+func parseWCPay(content string) (*wcPayContent, error) {
+	var result wcPayContent
+	if index := strings.Index(content, "<msg>"); index > 0 {
+		content = content[index:]
+	}
+	err := xml.Unmarshal([]byte(content), &result)
+	return &result, err
+}
+
+// Parse the amount in yuan from a fee description like "￥0.01".
+func parseFeeAmount(feeDesc string) float64 {
+	amount := strings.TrimLeft(strings.TrimSpace(feeDesc), "￥¥")
+	result, _ := strconv.ParseFloat(amount, 64)
+	return result
+}
+
+// Convert the pay sub type of a transfer to its status.
+func transferStatus(paySubType int) string {
+	switch paySubType {
+	case 1:
+		return "pending"
+	case 3:
+		return "received"
+	case 4:
+		return "returned"
+	case 5:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// App message content, only the fields used by the app message segments
+type appMessageContent struct {
+	XMLName xml.Name `xml:"msg"`
+	AppMsg  struct {
+		AppID             string `xml:"appid,attr"`
+		Title             string `xml:"title"`
+		Des               string `xml:"des"`
+		Type              int    `xml:"type"`
+		URL               string `xml:"url"`
+		DataURL           string `xml:"dataurl"`
+		LowURL            string `xml:"lowurl"`
+		LowDataURL        string `xml:"lowdataurl"`
+		ThumbURL          string `xml:"thumburl"`
+		SourceUserName    string `xml:"sourceusername"`
+		SourceDisplayName string `xml:"sourcedisplayname"`
+		SongAlbumURL      string `xml:"songalbumurl"`
+		SongLyric         string `xml:"songlyric"`
+		WeAppInfo         struct {
+			UserName       string `xml:"username"`
+			AppID          string `xml:"appid"`
+			PagePath       string `xml:"pagepath"`
+			Version        string `xml:"version"`
+			WeAppIconURL   string `xml:"weappiconurl"`
+			AppServiceType int    `xml:"appservicetype"`
+		} `xml:"weappinfo"`
+		FinderFeed struct {
+			ObjectID    string `xml:"objectId"`
+			ObjectNonce string `xml:"objectNonceId"`
+			FeedType    int    `xml:"feedType"`
+			NickName    string `xml:"nickname"`
+			UserName    string `xml:"username"`
+			Avatar      string `xml:"avatar"`
+			Desc        string `xml:"desc"`
+			MediaList   []struct {
+				MediaType int    `xml:"mediaType"`
+				URL       string `xml:"url"`
+				ThumbURL  string `xml:"thumbUrl"`
+				CoverURL  string `xml:"coverUrl"`
+				Width     string `xml:"width"`
+				Height    string `xml:"height"`
+				Duration  int    `xml:"videoPlayDuration"`
+			} `xml:"mediaList>media"`
+		} `xml:"finderFeed"`
+	} `xml:"appmsg"`
+	AppInfo struct {
+		AppName string `xml:"appname"`
+	} `xml:"appinfo"`
+}
+
+// Parse the content of an app message.
+func parseAppMessage(content string) (*appMessageContent, error) {
+	var result appMessageContent
+	if index := strings.Index(content, "<msg>"); index > 0 {
+		content = content[index:]
+	}
+	err := xml.Unmarshal([]byte(content), &result)
+	return &result, err
+}
+
+// Convert the scene of a friend add message to where the request comes from.
+func friendAddScene(scene int) string {
+	switch scene {
+	case 1:
+		return "qq"
+	case 2:
+		return "email"
+	case 3:
+		return "wechat_id"
+	case 13:
+		return "contacts"
+	case 14:
+		return "group"
+	case 15:
+		return "phone"
+	case 17:
+		return "card"
+	case 18:
+		return "nearby"
+	case 29:
+		return "shake"
+	case 30:
+		return "qrcode"
+	default:
+		return "unknown"
+	}
+}
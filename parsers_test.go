@@ -0,0 +1,76 @@
+package openwechat
+
+import "testing"
+
+// The payloads are hand-written in the shapes described on parseLocation, not captured from WeChat web.
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		mapURL  string
+		want    LocationType
+	}{
+		{
+			name:    "plain text",
+			content: "广东省深圳市南山区深南大道10000号(腾讯大厦):\n/cgi-bin/mmwebwx-bin/webwxgetpubliclinkimg?msgid=4503716398237446590&pictype=location",
+			mapURL:  "https://apis.map.qq.com/uri/v1/geocoder?coord=22.540503,113.934528&referer=wexinmp_profile",
+			want: LocationType{
+				Latitude:  22.540503,
+				Longitude: 113.934528,
+				Label:     "广东省深圳市南山区深南大道10000号",
+				POIName:   "腾讯大厦",
+				URL:       "https://apis.map.qq.com/uri/v1/geocoder?coord=22.540503,113.934528&referer=wexinmp_profile",
+			},
+		},
+		{
+			name:    "plain text without poi",
+			content: "北京市东城区东长安街:\n/cgi-bin/mmwebwx-bin/webwxgetpubliclinkimg?msgid=4503716398237446591&pictype=location",
+			mapURL:  "https://apis.map.qq.com/uri/v1/geocoder?coord=39.908823,116.39747",
+			want: LocationType{
+				Latitude:  39.908823,
+				Longitude: 116.39747,
+				Label:     "北京市东城区东长安街",
+				URL:       "https://apis.map.qq.com/uri/v1/geocoder?coord=39.908823,116.39747",
+			},
+		},
+		{
+			name:    "malformed coord",
+			content: "广东省深圳市南山区深南大道10000号(腾讯大厦):\n/cgi-bin/mmwebwx-bin/webwxgetpubliclinkimg?msgid=4503716398237446592&pictype=location",
+			mapURL:  "https://apis.map.qq.com/uri/v1/geocoder?coord=22.540503",
+			want: LocationType{
+				Label:   "广东省深圳市南山区深南大道10000号",
+				POIName: "腾讯大厦",
+				URL:     "https://apis.map.qq.com/uri/v1/geocoder?coord=22.540503",
+			},
+		},
+		{
+			name:    "missing coord",
+			content: "广东省深圳市南山区深南大道10000号(腾讯大厦):\n/cgi-bin/mmwebwx-bin/webwxgetpubliclinkimg?msgid=4503716398237446593&pictype=location",
+			mapURL:  "https://apis.map.qq.com/uri/v1/geocoder?referer=wexinmp_profile",
+			want: LocationType{
+				Label:   "广东省深圳市南山区深南大道10000号",
+				POIName: "腾讯大厦",
+				URL:     "https://apis.map.qq.com/uri/v1/geocoder?referer=wexinmp_profile",
+			},
+		},
+		{
+			name: "legacy xml",
+			content: "<?xml version=\"1.0\"?>\n<msg>\n\t<location x=\"39.908823\" y=\"116.397470\" scale=\"16\" " +
+				"label=\"北京市东城区东长安街\" maptype=\"roadmap\" poiname=\"天安门广场\" />\n</msg>\n",
+			want: LocationType{
+				Latitude:  39.908823,
+				Longitude: 116.39747,
+				Label:     "北京市东城区东长安街",
+				POIName:   "天安门广场",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseLocation(test.content, test.mapURL)
+			if got != test.want {
+				t.Errorf("parseLocation() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package openwechat
+
+import (
+	"sync"
+
+	"github.com/gonebot-dev/gonebot/message"
+)
+
+// SendWorkers is the max number of conversations sent to concurrently,
+// messages in the same conversation are always sent one by one in order.
+// Set it before the adapter starts, use SetSendWorkers to change it afterwards.
+var SendWorkers = 4
+
+// SetSendWorkers changes SendWorkers while the adapter is running, it takes effect immediately.
+func SetSendWorkers(n int) {
+	sendQueue.mu.Lock()
+	defer sendQueue.mu.Unlock()
+	SendWorkers = n
+	sendQueue.cond.Broadcast()
+}
+
+// SendQueueStats is a snapshot of the outgoing message queue.
+type SendQueueStats struct {
+	// Messages waiting to be sent
+	Pending int
+	// Messages being sent
+	Sending int
+	// Max number of pending messages ever seen
+	MaxPending int
+	// Pending messages of each conversation, keyed "friend UserName" or "group UserName"
+	Conversations map[string]int
+}
+
+// Outgoing messages queued by conversation, each conversation is drained by its own goroutine,
+// and the number of messages sent at the same time is bounded by SendWorkers.
+type conversationQueue struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	queues     map[string][]queuedMessage
+	sending    int
+	maxPending int
+}
+
+// A queued message and the callback to call with its result, done may be nil.
+type queuedMessage struct {
+	msg  message.Message
+	done func(err error)
+}
+
+var sendQueue = newConversationQueue()
+
+func newConversationQueue() *conversationQueue {
+	q := &conversationQueue{queues: make(map[string][]queuedMessage)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Key of the conversation of a message, references like "remark:Alice" are resolved to UserNames
+// so that a conversation is ordered however it is addressed.
+func conversationKey(msg message.Message) string {
+	if Self == nil {
+		return conversationName(msg.Receiver, msg.Group)
+	}
+	if msg.Group != "" {
+		if group, err := findGroup(msg.Group); err == nil {
+			return conversationName("", group.UserName)
+		}
+	} else if friend, err := findFriend(msg.Receiver); err == nil {
+		return conversationName(friend.UserName, "")
+	}
+	return conversationName(msg.Receiver, msg.Group)
+}
+
+func (q *conversationQueue) pending() int {
+	pending := 0
+	for _, queue := range q.queues {
+		pending += len(queue)
+	}
+	return pending
+}
+
+// Queue a message, starts a goroutine for its conversation if there isn't one.
+// Messages that fail are handed to DeadLetterHandler before done is called.
+func (q *conversationQueue) push(msg message.Message, done func(err error)) {
+	key := conversationKey(msg)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queue, running := q.queues[key]
+	q.queues[key] = append(queue, queuedMessage{msg: msg, done: done})
+	q.maxPending = max(q.maxPending, q.pending())
+	if !running {
+		go q.drain(key)
+	}
+}
+
+// Wait for a free worker and pop the next message of the conversation,
+// the conversation is removed when it is empty.
+func (q *conversationQueue) pop(key string) (queuedMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.sending >= max(SendWorkers, 1) {
+		q.cond.Wait()
+	}
+	queue := q.queues[key]
+	if len(queue) == 0 {
+		delete(q.queues, key)
+		return queuedMessage{}, false
+	}
+	q.queues[key] = queue[1:]
+	q.sending++
+	return queue[0], true
+}
+
+func (q *conversationQueue) drain(key string) {
+	for {
+		item, ok := q.pop(key)
+		if !ok {
+			return
+		}
+		err := sendMessage(item.msg)
+		if err != nil {
+			deadLetter(item.msg, err)
+		}
+		if item.done != nil {
+			item.done(err)
+		}
+		q.mu.Lock()
+		q.sending--
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}
+}
+
+// Drop all pending messages on shutdown, they are handed to DeadLetterHandler with ErrNotLoggedIn.
+// Messages being sent are finished by their goroutines, which exit when their conversations are empty.
+func (q *conversationQueue) Clear() {
+	q.mu.Lock()
+	dropped := []queuedMessage{}
+	for key, queue := range q.queues {
+		dropped = append(dropped, queue...)
+		q.queues[key] = nil
+	}
+	q.mu.Unlock()
+	for _, item := range dropped {
+		deadLetter(item.msg, ErrNotLoggedIn)
+		if item.done != nil {
+			item.done(ErrNotLoggedIn)
+		}
+	}
+}
+
+func (q *conversationQueue) stats() SendQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := SendQueueStats{
+		Pending:       q.pending(),
+		Sending:       q.sending,
+		MaxPending:    q.maxPending,
+		Conversations: make(map[string]int, len(q.queues)),
+	}
+	for key, queue := range q.queues {
+		if len(queue) > 0 {
+			stats.Conversations[key] = len(queue)
+		}
+	}
+	return stats
+}
+
+// GetSendQueueStats returns the depth of the outgoing message queue.
+func GetSendQueueStats() SendQueueStats {
+	return sendQueue.stats()
+}
@@ -0,0 +1,180 @@
+package openwechat
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gonebot-dev/gonebot/logging"
+	"github.com/rs/zerolog"
+)
+
+// RateLimit is a token bucket, a rate of 0 or less disables it.
+type RateLimit struct {
+	// Messages allowed per second on average
+	Rate float64
+	// Messages allowed in a burst
+	Burst int
+}
+
+// RateLimitPolicy describes how fast messages are sent, messages exceeding the limit wait instead of being dropped.
+type RateLimitPolicy struct {
+	// Limit of all messages
+	Global RateLimit
+	// Limit of messages to each friend
+	PerContact RateLimit
+	// Limit of messages to each group
+	PerGroup RateLimit
+	// Minimum interval between two consecutive messages
+	MinInterval time.Duration
+	// A random delay between RandomDelayMin and RandomDelayMax is added before every message
+	RandomDelayMin time.Duration
+	RandomDelayMax time.Duration
+}
+
+// SendRateLimit is the rate limit policy used for every send, including retries.
+var SendRateLimit = RateLimitPolicy{
+	Global:      RateLimit{Rate: 1, Burst: 5},
+	PerContact:  RateLimit{Rate: 0.5, Burst: 3},
+	PerGroup:    RateLimit{Rate: 0.5, Burst: 3},
+	MinInterval: 500 * time.Millisecond,
+}
+
+// Full buckets are pruned once there are more buckets than this.
+const rateLimitBucketLimit = 1024
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (limit RateLimit) enabled() bool {
+	return limit.Rate > 0
+}
+
+func (limit RateLimit) burst() float64 {
+	if limit.Burst < 1 {
+		return 1
+	}
+	return float64(limit.Burst)
+}
+
+// Refill the bucket, a new bucket starts full.
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = limit.burst()
+	} else {
+		b.tokens = min(limit.burst(), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	}
+	b.last = now
+}
+
+// How long to wait for the next token.
+func (b *tokenBucket) delay(limit RateLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	global   tokenBucket
+	contacts map[string]*tokenBucket
+	groups   map[string]*tokenBucket
+	lastSend time.Time
+}
+
+var sendLimiter = &rateLimiter{
+	contacts: make(map[string]*tokenBucket),
+	groups:   make(map[string]*tokenBucket),
+}
+
+func bucketOf(buckets map[string]*tokenBucket, key string) *tokenBucket {
+	bucket, ok := buckets[key]
+	if !ok {
+		bucket = &tokenBucket{}
+		buckets[key] = bucket
+	}
+	return bucket
+}
+
+// Drop full buckets, they are the same as new ones.
+func pruneBuckets(buckets map[string]*tokenBucket, limit RateLimit, now time.Time) {
+	for key, bucket := range buckets {
+		bucket.refill(limit, now)
+		if bucket.tokens >= limit.burst() {
+			delete(buckets, key)
+		}
+	}
+}
+
+// Try to take a token from every bucket of the conversation, returns how long to wait if it fails.
+func (l *rateLimiter) reserve(policy RateLimitPolicy, receiver, group string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	limits := make([]RateLimit, 0, 2)
+	buckets := make([]*tokenBucket, 0, 2)
+	if policy.Global.enabled() {
+		limits = append(limits, policy.Global)
+		buckets = append(buckets, &l.global)
+	}
+	if group == "" && policy.PerContact.enabled() {
+		if len(l.contacts) > rateLimitBucketLimit {
+			pruneBuckets(l.contacts, policy.PerContact, now)
+		}
+		limits = append(limits, policy.PerContact)
+		buckets = append(buckets, bucketOf(l.contacts, receiver))
+	} else if group != "" && policy.PerGroup.enabled() {
+		if len(l.groups) > rateLimitBucketLimit {
+			pruneBuckets(l.groups, policy.PerGroup, now)
+		}
+		limits = append(limits, policy.PerGroup)
+		buckets = append(buckets, bucketOf(l.groups, group))
+	}
+	delay := time.Duration(0)
+	if !l.lastSend.IsZero() {
+		delay = l.lastSend.Add(policy.MinInterval).Sub(now)
+	}
+	for i, bucket := range buckets {
+		bucket.refill(limits[i], now)
+		delay = max(delay, bucket.delay(limits[i]))
+	}
+	if delay > 0 {
+		return delay
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	l.lastSend = now
+	return 0
+}
+
+// Block until a message can be sent to the conversation.
+func (l *rateLimiter) wait(receiver, group string) {
+	policy := SendRateLimit
+	if policy.RandomDelayMax > policy.RandomDelayMin {
+		time.Sleep(policy.RandomDelayMin + time.Duration(rand.Int63n(int64(policy.RandomDelayMax-policy.RandomDelayMin))))
+	} else if policy.RandomDelayMin > 0 {
+		time.Sleep(policy.RandomDelayMin)
+	}
+	for {
+		delay := l.reserve(policy, receiver, group)
+		if delay <= 0 {
+			return
+		}
+		logging.Logf(zerolog.DebugLevel, "OpenWechat", "rateLimiter: Message to %s is delayed for %s.", conversationName(receiver, group), delay)
+		time.Sleep(delay)
+	}
+}
+
+// Reset all buckets.
+func (l *rateLimiter) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.global = tokenBucket{}
+	l.contacts = make(map[string]*tokenBucket)
+	l.groups = make(map[string]*tokenBucket)
+	l.lastSend = time.Time{}
+}
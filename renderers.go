@@ -0,0 +1,101 @@
+package openwechat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gonebot-dev/gonebot/message"
+)
+
+// ErrUnsupportedSegment is returned for outgoing segments whose type has no renderer.
+var ErrUnsupportedSegment = errors.New("unsupported segment")
+
+// SegmentRenderer describes how an outgoing segment type is sent, set exactly one of Inline and Send.
+type SegmentRenderer struct {
+	// Render the segment as text, it is merged with the text segments next to it
+	Inline func(segment message.MessageSegment) (string, error)
+	// Send the segment as a standalone message, pending text is sent before it
+	Send func(receiver, group string, segment message.MessageSegment) error
+	// Decode the JSON data of the segment, needed to persist scheduled messages, leave nil if they are not scheduled
+	Decode func(data json.RawMessage) (message.MessageType, error)
+}
+
+var segmentRenderers = struct {
+	sync.RWMutex
+	renderers map[string]SegmentRenderer
+}{renderers: make(map[string]SegmentRenderer)}
+
+// RegisterSegmentRenderer sets how outgoing segments of the type are sent, replacing the existing renderer.
+func RegisterSegmentRenderer(segmentType string, renderer SegmentRenderer) {
+	segmentRenderers.Lock()
+	defer segmentRenderers.Unlock()
+	segmentRenderers.renderers[segmentType] = renderer
+}
+
+func getSegmentRenderer(segmentType string) (SegmentRenderer, bool) {
+	segmentRenderers.RLock()
+	defer segmentRenderers.RUnlock()
+	renderer, ok := segmentRenderers.renderers[segmentType]
+	return renderer, ok
+}
+
+// Wrap a typed send function as a renderer, segments with unexpected data are reported instead of panicking.
+func sendAs[T message.MessageType](send func(receiver, group string, data T) error) SegmentRenderer {
+	return SegmentRenderer{
+		Send: func(receiver, group string, segment message.MessageSegment) error {
+			data, ok := segment.Data.(T)
+			if !ok {
+				return fmt.Errorf("%w: %s segment with data %T", ErrUnsupportedSegment, segment.Type, segment.Data)
+			}
+			return send(receiver, group, data)
+		},
+		Decode: decodeAs[T],
+	}
+}
+
+// Decode JSON data as the segment type.
+func decodeAs[T message.MessageType](data json.RawMessage) (message.MessageType, error) {
+	var result T
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func init() {
+	RegisterSegmentRenderer("text", SegmentRenderer{
+		Inline: func(segment message.MessageSegment) (string, error) {
+			text, ok := segment.Data.(message.TextType)
+			if !ok {
+				return "", fmt.Errorf("%w: text segment with data %T", ErrUnsupportedSegment, segment.Data)
+			}
+			return text.Text, nil
+		},
+		Decode: decodeAs[message.TextType],
+	})
+	RegisterSegmentRenderer("face", SegmentRenderer{
+		Inline: func(segment message.MessageSegment) (string, error) {
+			face, ok := segment.Data.(FaceType)
+			if !ok {
+				return "", fmt.Errorf("%w: face segment with data %T", ErrUnsupportedSegment, segment.Data)
+			}
+			code := faceCode(face)
+			if code == "" {
+				return "", fmt.Errorf("unknown face: %s", segment.Data.ToRawText(segment))
+			}
+			return code, nil
+		},
+		Decode: decodeAs[FaceType],
+	})
+	// Metadata of received messages carries nothing to send
+	RegisterSegmentRenderer("metadata", SegmentRenderer{Inline: func(segment message.MessageSegment) (string, error) {
+		return "", nil
+	}})
+	RegisterSegmentRenderer("image", sendAs(sendImage))
+	RegisterSegmentRenderer("file", sendAs(sendFile))
+	RegisterSegmentRenderer("video", sendAs(sendVideo))
+	RegisterSegmentRenderer("voice", sendAs(sendVoice))
+	RegisterSegmentRenderer("sticker", sendAs(sendSticker))
+}
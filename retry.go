@@ -0,0 +1,120 @@
+package openwechat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/gonebot-dev/gonebot/logging"
+	"github.com/gonebot-dev/gonebot/message"
+	"github.com/rs/zerolog"
+)
+
+// RetryPolicy describes how failed sends are retried with exponential backoff.
+type RetryPolicy struct {
+	// Max attempts including the first one, 1 or less disables retrying
+	MaxAttempts int
+	// Delay before the first retry
+	BaseDelay time.Duration
+	// Upper bound of the delay between retries
+	MaxDelay time.Duration
+	// How much the delay grows after every retry
+	Multiplier float64
+	// Fraction of the delay to randomize, 0.2 means ±20%
+	Jitter float64
+}
+
+// SendRetryPolicy is the retry policy used for every send.
+var SendRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+	Multiplier:  2,
+	Jitter:      0.2,
+}
+
+// DeadLetterHandler receives outgoing messages that ultimately failed to send and the reason,
+// leave it nil to only log them.
+var DeadLetterHandler func(msg message.Message, err error)
+
+// WeChat web ret codes that are worth retrying
+var retryableRets = map[openwechat.Ret]bool{
+	-1:   true, // sys error
+	1100: true, // failed login warn
+	1101: true, // failed login check
+	1205: true, // operate too often
+}
+
+// IsRetryableError reports whether a send error is transient, e.g. network errors and some WeChat web ret codes.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var ret openwechat.Ret
+	if errors.As(err, &ret) {
+		return retryableRets[ret]
+	}
+	var netErr net.Error
+	return errors.Is(err, openwechat.NetworkErr) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// Delay before the given retry, starting from 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(delay)
+}
+
+// Call send until it succeeds, fails permanently or runs out of attempts,
+// every attempt waits for the rate limiter of the conversation first.
+func sendWithRetry(name, receiver, group string, send func() (*openwechat.SentMessage, error)) error {
+	policy := SendRetryPolicy
+	var err error
+	for attempt := 1; ; attempt++ {
+		sendLimiter.wait(receiver, group)
+		if err = recordSent(send()); err == nil {
+			return nil
+		}
+		if !IsRetryableError(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+		delay := policy.delay(attempt)
+		logging.Logf(zerolog.WarnLevel, "OpenWechat", "%s: Attempt %d failed: %s, retrying in %s.", name, attempt, err.Error(), delay)
+		time.Sleep(delay)
+	}
+}
+
+// Hand a message that failed to send to DeadLetterHandler.
+func deadLetter(msg message.Message, err error) {
+	logging.Logf(zerolog.ErrorLevel, "OpenWechat", "sendMessage: Message to %s failed: %s", conversationName(msg.Receiver, msg.Group), err.Error())
+	if DeadLetterHandler != nil {
+		DeadLetterHandler(msg, err)
+	}
+}
+
+func conversationName(receiver, group string) string {
+	if group != "" {
+		return "group " + group
+	}
+	return "friend " + receiver
+}
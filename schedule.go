@@ -0,0 +1,331 @@
+package openwechat
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gonebot-dev/gonebot/logging"
+	"github.com/gonebot-dev/gonebot/message"
+	"github.com/rs/zerolog"
+)
+
+// ScheduleStoragePath is where scheduled messages are persisted,
+// leave it empty to use .openwechat-hotlogin/scheduled.json next to the executable.
+var ScheduleStoragePath = ""
+
+// ScheduleAction queues the message to be sent at the given time or after the given delay,
+// call it with OpenWechat.CallAction and the result is a ScheduleResult.
+type ScheduleAction struct {
+	// When to send, used if it is not zero
+	At time.Time
+	// How long to wait before sending, used if At is zero
+	Delay time.Duration
+	// The message to send, Receiver or Group must be set
+	Message message.Message
+}
+
+// ScheduleResult is the result of a ScheduleAction.
+type ScheduleResult struct {
+	// ID used to cancel the message
+	ID  string
+	At  time.Time
+	Err error
+}
+
+// CancelScheduleAction cancels a scheduled message by its ID, the result is a CancelScheduleResult.
+type CancelScheduleAction struct {
+	ID string
+}
+
+// CancelScheduleResult is the result of a CancelScheduleAction, Canceled is false if no message has the ID.
+type CancelScheduleResult struct {
+	Canceled bool
+	Err      error
+}
+
+// ListScheduleAction lists the pending scheduled messages, the result is a []ScheduledMessage sorted by time.
+type ListScheduleAction struct{}
+
+// ScheduledMessage is a pending scheduled message.
+type ScheduledMessage struct {
+	ID      string
+	At      time.Time
+	Message message.Message
+}
+
+// Persisted form of a segment, Data is decoded with the Decode function of the segment renderer.
+type storedSegment struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type storedSchedule struct {
+	ID       string          `json:"id"`
+	At       time.Time       `json:"at"`
+	Receiver string          `json:"receiver"`
+	Group    string          `json:"group"`
+	Segments []storedSegment `json:"segments"`
+}
+
+type scheduler struct {
+	mu      sync.Mutex
+	entries map[string]storedSchedule
+	timers  map[string]*time.Timer
+	// Increased by Clear, messages dispatched before it never touch the storage again
+	generation int
+	// Set until the storage is loaded and after Clear, so that the storage is never overwritten with partial entries
+	closed bool
+}
+
+var schedules = &scheduler{
+	entries: make(map[string]storedSchedule),
+	timers:  make(map[string]*time.Timer),
+	closed:  true,
+}
+
+// ErrSchedulerStopped is returned when scheduling or canceling messages before login or after shutdown.
+var ErrSchedulerStopped = errors.New("scheduler stopped")
+
+func scheduleStoragePath() string {
+	if ScheduleStoragePath != "" {
+		return ScheduleStoragePath
+	}
+	return filepath.Join(filepath.Dir(os.Args[0]), ".openwechat-hotlogin/scheduled.json")
+}
+
+func newScheduleID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Convert a message to its persisted form, every segment must have a decoder to be restored.
+func encodeSchedule(id string, at time.Time, msg message.Message) (storedSchedule, error) {
+	entry := storedSchedule{ID: id, At: at, Receiver: msg.Receiver, Group: msg.Group}
+	for _, segment := range msg.GetSegments() {
+		renderer, ok := getSegmentRenderer(segment.Type)
+		if !ok || renderer.Decode == nil {
+			return entry, fmt.Errorf("%w: %s segment cannot be scheduled", ErrUnsupportedSegment, segment.Type)
+		}
+		data, err := json.Marshal(segment.Data)
+		if err != nil {
+			return entry, fmt.Errorf("unable to encode %s segment: %w", segment.Type, err)
+		}
+		entry.Segments = append(entry.Segments, storedSegment{Type: segment.Type, Data: data})
+	}
+	return entry, nil
+}
+
+func decodeSchedule(entry storedSchedule) (message.Message, error) {
+	msg := message.NewMessage()
+	msg.Receiver = entry.Receiver
+	msg.Group = entry.Group
+	for _, segment := range entry.Segments {
+		renderer, ok := getSegmentRenderer(segment.Type)
+		if !ok || renderer.Decode == nil {
+			return *msg, fmt.Errorf("%w: %s segment cannot be restored", ErrUnsupportedSegment, segment.Type)
+		}
+		data, err := renderer.Decode(segment.Data)
+		if err != nil {
+			return *msg, fmt.Errorf("unable to decode %s segment: %w", segment.Type, err)
+		}
+		msg.AttachSegment(message.MessageSegment{Type: segment.Type, Data: data})
+	}
+	return *msg, nil
+}
+
+// Write all entries to the storage, the caller must hold the lock.
+// Nothing is written while closed, the entries may be partial then.
+func (s *scheduler) save() error {
+	if s.closed {
+		return nil
+	}
+	entries := make([]storedSchedule, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].At.Before(entries[j].At) })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := scheduleStoragePath()
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	// Write to a temp file first so that a crash never leaves a broken storage
+	if err = os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Start the timer of an entry, the caller must hold the lock.
+func (s *scheduler) arm(entry storedSchedule) {
+	s.timers[entry.ID] = time.AfterFunc(time.Until(entry.At), func() {
+		s.dispatch(entry.ID)
+	})
+}
+
+// Hand a due message to the send queue, it is removed from the storage after it is sent.
+func (s *scheduler) dispatch(id string) {
+	s.mu.Lock()
+	entry, ok := s.entries[id]
+	generation := s.generation
+	delete(s.timers, id)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	msg, err := decodeSchedule(entry)
+	done := func(err error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// The scheduler is cleared on shutdown, saving now would wipe the storage,
+		// so the message stays in it and is sent again after restarting
+		if s.generation != generation {
+			return
+		}
+		delete(s.entries, id)
+		if err := s.save(); err != nil {
+			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "scheduler: Unable to save scheduled messages: %s", err.Error())
+		}
+	}
+	if err != nil {
+		deadLetter(msg, err)
+		done(err)
+		return
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "scheduler: Sending scheduled message %s to %s.", id, conversationName(entry.Receiver, entry.Group))
+	sendQueue.push(msg, done)
+}
+
+func (s *scheduler) schedule(action ScheduleAction) ScheduleResult {
+	at := action.At
+	if at.IsZero() {
+		at = time.Now().Add(action.Delay)
+	}
+	msg := action.Message
+	if msg.Receiver == "" && msg.Group == "" {
+		return ScheduleResult{At: at, Err: errors.New("no receiver or group")}
+	}
+	if Self == nil {
+		return ScheduleResult{At: at, Err: ErrSchedulerStopped}
+	}
+	// Session UserNames change after restarting, store references that can be resolved again
+	var err error
+	if msg.Group != "" {
+		msg.Receiver = ""
+		msg.Group, err = stableContactRef(msg.Group, true)
+	} else {
+		msg.Receiver, err = stableContactRef(msg.Receiver, false)
+	}
+	if err != nil {
+		return ScheduleResult{At: at, Err: fmt.Errorf("unable to schedule: %w", err)}
+	}
+	entry, err := encodeSchedule(newScheduleID(), at, msg)
+	if err != nil {
+		return ScheduleResult{At: at, Err: err}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ScheduleResult{At: at, Err: ErrSchedulerStopped}
+	}
+	s.entries[entry.ID] = entry
+	if err = s.save(); err != nil {
+		delete(s.entries, entry.ID)
+		return ScheduleResult{At: at, Err: fmt.Errorf("unable to save scheduled messages: %w", err)}
+	}
+	s.arm(entry)
+	return ScheduleResult{ID: entry.ID, At: at}
+}
+
+func (s *scheduler) cancel(id string) CancelScheduleResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return CancelScheduleResult{Err: ErrSchedulerStopped}
+	}
+	timer, ok := s.timers[id]
+	// Messages whose timers have fired are being sent and cannot be canceled
+	if !ok || !timer.Stop() {
+		return CancelScheduleResult{}
+	}
+	delete(s.timers, id)
+	entry := s.entries[id]
+	delete(s.entries, id)
+	if err := s.save(); err != nil {
+		s.entries[id] = entry
+		s.arm(entry)
+		return CancelScheduleResult{Err: fmt.Errorf("unable to save scheduled messages: %w", err)}
+	}
+	return CancelScheduleResult{Canceled: true}
+}
+
+func (s *scheduler) list() []ScheduledMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]ScheduledMessage, 0, len(s.timers))
+	for id := range s.timers {
+		entry := s.entries[id]
+		msg, err := decodeSchedule(entry)
+		if err != nil {
+			continue
+		}
+		result = append(result, ScheduledMessage{ID: id, At: entry.At, Message: msg})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].At.Before(result[j].At) })
+	return result
+}
+
+// Restore persisted messages, overdue ones are sent immediately.
+// If the storage cannot be read, scheduling stays disabled rather than overwriting it.
+func (s *scheduler) load() {
+	var entries []storedSchedule
+	data, err := os.ReadFile(scheduleStoragePath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "scheduler: Unable to read scheduled messages, scheduling is disabled: %s", err.Error())
+		return
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, &entries); err != nil {
+			logging.Logf(zerolog.ErrorLevel, "OpenWechat", "scheduler: Unable to parse scheduled messages, scheduling is disabled: %s", err.Error())
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = false
+	for _, entry := range entries {
+		if _, ok := s.entries[entry.ID]; ok {
+			continue
+		}
+		s.entries[entry.ID] = entry
+		s.arm(entry)
+	}
+	if len(entries) > 0 {
+		logging.Logf(zerolog.InfoLevel, "OpenWechat", "scheduler: Restored %d scheduled messages.", len(entries))
+	}
+}
+
+// Stop all timers, the messages stay in the storage.
+func (s *scheduler) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	s.closed = true
+	for _, timer := range s.timers {
+		timer.Stop()
+	}
+	s.entries = make(map[string]storedSchedule)
+	s.timers = make(map[string]*time.Timer)
+}
@@ -2,12 +2,99 @@ package openwechat
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/eatmoreapple/openwechat"
 	"github.com/gonebot-dev/gonebot/message"
 )
 
-type LocationType struct{}
+type SenderProfile struct {
+	UserName string `json:"user_name"`
+	// Display name in group, empty for friend messages
+	DisplayName string `json:"display_name"`
+	NickName    string `json:"nick_name"`
+	RemarkName  string `json:"remark_name"`
+	// Avatar url relative to the web client, changes with the avatar as AvatarID does
+	AvatarURL string `json:"avatar_url"`
+	AvatarID  string `json:"avatar_id"`
+}
+
+// Metadata is attached as the last segment of every incoming message.
+type MetadataType struct {
+	MsgID    string `json:"msg_id"`
+	NewMsgID string `json:"new_msg_id"`
+	// Server timestamp in seconds
+	CreateTime int64         `json:"create_time"`
+	MsgType    int           `json:"msg_type"`
+	AppMsgType int           `json:"app_msg_type"`
+	SenderName string        `json:"sender_name"`
+	Sender     SenderProfile `json:"sender"`
+	// Whether the message is sent by the logged-in account from another device
+	IsSelf bool `json:"is_self"`
+}
+
+func (metadata MetadataType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (metadata MetadataType) TypeName() string {
+	return "metadata"
+}
+
+// Metadata does not contribute to the raw text, so that text matching rules are not affected.
+func (metadata MetadataType) ToRawText(msg message.MessageSegment) string {
+	return ""
+}
+
+type FaceType struct {
+	Name string `json:"name"`
+	ID   int    `json:"id"`
+}
+
+func (face FaceType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (face FaceType) TypeName() string {
+	return "face"
+}
+
+func (face FaceType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(FaceType)
+	if code := faceCode(result); code != "" {
+		return code
+	}
+	return fmt.Sprintf("[OpenWechat:face,name=%s,id=%d]", result.Name, result.ID)
+}
+
+type StickerType struct {
+	MD5    string `json:"md5"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	File   string `json:"file"`
+}
+
+func (sticker StickerType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (sticker StickerType) TypeName() string {
+	return "sticker"
+}
+
+func (sticker StickerType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(StickerType)
+	return fmt.Sprintf("[OpenWechat:sticker,md5=%s]", result.MD5)
+}
+
+type LocationType struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Label     string  `json:"label"`
+	POIName   string  `json:"poi_name"`
+	URL       string  `json:"url"`
+}
 
 func (loc LocationType) AdapterName() string {
 	return OpenWechat.Name
@@ -18,7 +105,8 @@ func (loc LocationType) TypeName() string {
 }
 
 func (loc LocationType) ToRawText(msg message.MessageSegment) string {
-	return "[OpenWechat:location]"
+	result := msg.Data.(LocationType)
+	return fmt.Sprintf("[OpenWechat:location,latitude=%f,longitude=%f,label=%s,poi_name=%s]", result.Latitude, result.Longitude, result.Label, result.POIName)
 }
 
 type RealtimeLocationStartType struct{}
@@ -49,15 +137,79 @@ func (rls RealtimeLocationStopType) ToRawText(msg message.MessageSegment) string
 	return "[OpenWechat:realtime_location_stop]"
 }
 
+type VoiceInfoType struct {
+	Format   string `json:"format"`
+	Duration int    `json:"duration"`
+}
+
+func (vi VoiceInfoType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (vi VoiceInfoType) TypeName() string {
+	return "voice_info"
+}
+
+func (vi VoiceInfoType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(VoiceInfoType)
+	return fmt.Sprintf("[OpenWechat:voice_info,format=%s,duration=%d]", result.Format, result.Duration)
+}
+
+// Gender of a WeChat user
+type Gender int
+
+const (
+	GenderUnknown Gender = 0
+	GenderMale    Gender = 1
+	GenderFemale  Gender = 2
+)
+
+func (g Gender) String() string {
+	switch g {
+	case GenderMale:
+		return "male"
+	case GenderFemale:
+		return "female"
+	default:
+		return "unknown"
+	}
+}
+
+func (g Gender) MarshalText() ([]byte, error) {
+	return []byte(g.String()), nil
+}
+
+func (g *Gender) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "male":
+		*g = GenderMale
+	case "female":
+		*g = GenderFemale
+	default:
+		*g = GenderUnknown
+	}
+	return nil
+}
+
 type FriendAddType struct {
-	NickName string              `json:"NickName"`
-	UserName string              `json:"UserName"`
-	WechatID string              `json:"wechat_id"`
-	Sex      string              `json:"sex"`
-	Country  string              `json:"country"`
-	Province string              `json:"province"`
-	City     string              `json:"city"`
-	Msg      *openwechat.Message `json:"msg"`
+	NickName  string `json:"NickName"`
+	UserName  string `json:"UserName"`
+	WechatID  string `json:"wechat_id"`
+	Sex       Gender `json:"sex"`
+	Country   string `json:"country"`
+	Province  string `json:"province"`
+	City      string `json:"city"`
+	Signature string `json:"signature"`
+	AvatarURL string `json:"avatar_url"`
+	// The verify message sent with the request
+	VerifyContent string `json:"verify_content"`
+	Scene         int    `json:"scene"`
+	// Where the request comes from, e.g. "wechat_id", "group", "qrcode", "card", see friendAddScene
+	SceneName string `json:"scene_name"`
+	// Ticket needed to accept the request, Msg.Agree() uses it
+	Ticket       string              `json:"ticket"`
+	AutoAccepted bool                `json:"auto_accepted"`
+	Msg          *openwechat.Message `json:"msg"`
 }
 
 func (fa FriendAddType) AdapterName() string {
@@ -70,16 +222,18 @@ func (fa FriendAddType) TypeName() string {
 
 func (fa FriendAddType) ToRawText(msg message.MessageSegment) string {
 	result := msg.Data.(FriendAddType)
-	return fmt.Sprintf("[OpenWechat:friend_add,NickName=%s,sex=%s,country=%s,province=%s,city=%s]", result.NickName, result.Sex, result.Country, result.Province, result.City)
+	return fmt.Sprintf("[OpenWechat:friend_add,NickName=%s,sex=%s,country=%s,province=%s,city=%s,scene=%s,verify_content=%s]", result.NickName, result.Sex, result.Country, result.Province, result.City, result.SceneName, result.VerifyContent)
 }
 
 type CardType struct {
-	NickName string `json:"NickName"`
-	UserName string `json:"UserName"`
-	WechatID string `json:"wechat_id"`
-	Sex      string `json:"sex"`
-	Province string `json:"province"`
-	City     string `json:"city"`
+	NickName  string `json:"NickName"`
+	UserName  string `json:"UserName"`
+	WechatID  string `json:"wechat_id"`
+	Sex       Gender `json:"sex"`
+	Province  string `json:"province"`
+	City      string `json:"city"`
+	Signature string `json:"signature"`
+	AvatarURL string `json:"avatar_url"`
 }
 
 func (card CardType) AdapterName() string {
@@ -98,6 +252,11 @@ func (card CardType) ToRawText(msg message.MessageSegment) string {
 type RecallType struct {
 	Recaller   string `json:"recaller"`
 	ReplaceMsg string `json:"replace_msg"`
+	// MsgId of the recalled message
+	MsgID string `json:"msg_id"`
+	// Sender and segments of the recalled message, empty if it is no longer in the recent message store
+	Sender   string                   `json:"sender"`
+	Segments []message.MessageSegment `json:"segments"`
 }
 
 func (recall RecallType) AdapterName() string {
@@ -110,10 +269,23 @@ func (recall RecallType) TypeName() string {
 
 func (recall RecallType) ToRawText(msg message.MessageSegment) string {
 	result := msg.Data.(RecallType)
-	return fmt.Sprintf("[OpenWechat:recall,recaller=%s,replace_msg=%s]", result.Recaller, result.ReplaceMsg)
+	return fmt.Sprintf("[OpenWechat:recall,recaller=%s,replace_msg=%s,msg_id=%s]", result.Recaller, result.ReplaceMsg, result.MsgID)
 }
 
-type TransferType struct{}
+type TransferType struct {
+	// "sent" or "received"
+	Direction string  `json:"direction"`
+	Amount    float64 `json:"amount"`
+	FeeDesc   string  `json:"fee_desc"`
+	Memo      string  `json:"memo"`
+	// "pending", "received", "returned", "expired" or "unknown"
+	Status        string `json:"status"`
+	TransferID    string `json:"transfer_id"`
+	TransactionID string `json:"transaction_id"`
+	Description   string `json:"description"`
+	BeginTime     int64  `json:"begin_time"`
+	InvalidTime   int64  `json:"invalid_time"`
+}
 
 func (transfer TransferType) AdapterName() string {
 	return OpenWechat.Name
@@ -124,10 +296,17 @@ func (transfer TransferType) TypeName() string {
 }
 
 func (transfer TransferType) ToRawText(msg message.MessageSegment) string {
-	return "[OpenWechat:transfer]"
+	result := msg.Data.(TransferType)
+	return fmt.Sprintf("[OpenWechat:transfer,direction=%s,amount=%.2f,status=%s,memo=%s,transfer_id=%s]", result.Direction, result.Amount, result.Status, result.Memo, result.TransferID)
 }
 
-type RedPacketType struct{}
+type RedPacketType struct {
+	// "sent" or "received"
+	Direction   string `json:"direction"`
+	Memo        string `json:"memo"`
+	SceneText   string `json:"scene_text"`
+	Description string `json:"description"`
+}
 
 func (redPacket RedPacketType) AdapterName() string {
 	return OpenWechat.Name
@@ -138,11 +317,134 @@ func (redPacket RedPacketType) TypeName() string {
 }
 
 func (redPacket RedPacketType) ToRawText(msg message.MessageSegment) string {
-	return "[OpenWechat:red_packet]"
+	result := msg.Data.(RedPacketType)
+	return fmt.Sprintf("[OpenWechat:red_packet,direction=%s,memo=%s]", result.Direction, result.Memo)
+}
+
+// App message types not defined by openwechat
+const (
+	appMsgTypeMusic           openwechat.AppMessageType = 3
+	appMsgTypeMiniProgram     openwechat.AppMessageType = 33
+	appMsgTypeMiniProgramPage openwechat.AppMessageType = 36
+	appMsgTypeChannels        openwechat.AppMessageType = 51
+)
+
+type MiniProgramType struct {
+	Title    string `json:"title"`
+	AppName  string `json:"app_name"`
+	AppID    string `json:"app_id"`
+	UserName string `json:"user_name"`
+	PagePath string `json:"page_path"`
+	Cover    string `json:"cover"`
+	URL      string `json:"url"`
+}
+
+func (miniProgram MiniProgramType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (miniProgram MiniProgramType) TypeName() string {
+	return "mini_program"
+}
+
+func (miniProgram MiniProgramType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(MiniProgramType)
+	return fmt.Sprintf("[OpenWechat:mini_program,title=%s,app_name=%s,app_id=%s,page_path=%s]", result.Title, result.AppName, result.AppID, result.PagePath)
+}
+
+type MusicType struct {
+	Title   string `json:"title"`
+	Singer  string `json:"singer"`
+	URL     string `json:"url"`
+	DataURL string `json:"data_url"`
+	Cover   string `json:"cover"`
+	AppName string `json:"app_name"`
+}
+
+func (music MusicType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (music MusicType) TypeName() string {
+	return "music"
+}
+
+func (music MusicType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(MusicType)
+	return fmt.Sprintf("[OpenWechat:music,title=%s,singer=%s,url=%s]", result.Title, result.Singer, result.URL)
+}
+
+type ChannelType struct {
+	Title    string `json:"title"`
+	Desc     string `json:"desc"`
+	NickName string `json:"nickname"`
+	ObjectID string `json:"object_id"`
+	Cover    string `json:"cover"`
+	URL      string `json:"url"`
+}
+
+func (channel ChannelType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (channel ChannelType) TypeName() string {
+	return "channel"
+}
+
+func (channel ChannelType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(ChannelType)
+	return fmt.Sprintf("[OpenWechat:channel,nickname=%s,desc=%s]", result.NickName, result.Desc)
+}
+
+type AppMessageType struct {
+	AppMsgType int    `json:"app_msg_type"`
+	Title      string `json:"title"`
+	Des        string `json:"des"`
+	URL        string `json:"url"`
+	// Raw xml content of the app message
+	Content string `json:"content"`
+}
+
+func (appMessage AppMessageType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (appMessage AppMessageType) TypeName() string {
+	return "app_message"
+}
+
+func (appMessage AppMessageType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(AppMessageType)
+	return fmt.Sprintf("[OpenWechat:app_message,app_msg_type=%d,title=%s]", result.AppMsgType, result.Title)
+}
+
+type RawType struct {
+	MsgType    int `json:"msg_type"`
+	AppMsgType int `json:"app_msg_type"`
+	// Raw content or xml of the message
+	Content string `json:"content"`
+}
+
+func (raw RawType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (raw RawType) TypeName() string {
+	return "raw"
+}
+
+func (raw RawType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(RawType)
+	return fmt.Sprintf("[OpenWechat:raw,msg_type=%d,app_msg_type=%d]", result.MsgType, result.AppMsgType)
 }
 
 type TickleType struct {
-	Msg string `json:"msg"`
+	Actor      string `json:"actor"`
+	ActorName  string `json:"actor_name"`
+	Target     string `json:"target"`
+	TargetName string `json:"target_name"`
+	Suffix     string `json:"suffix"`
+	Msg        string `json:"msg"`
 }
 
 func (tickle TickleType) AdapterName() string {
@@ -154,10 +456,18 @@ func (tickle TickleType) TypeName() string {
 }
 
 func (tickle TickleType) ToRawText(msg message.MessageSegment) string {
-	return fmt.Sprintf("[OpenWechat:tickle,msg=%s]", tickle.Msg)
+	result := msg.Data.(TickleType)
+	return fmt.Sprintf("[OpenWechat:tickle,actor=%s,target=%s,suffix=%s,msg=%s]", result.ActorName, result.TargetName, result.Suffix, result.Msg)
 }
 
-type JoinGroupType struct{}
+type JoinGroupType struct {
+	Inviter     string   `json:"inviter"`
+	InviterName string   `json:"inviter_name"`
+	Members     []string `json:"members"`
+	MemberNames []string `json:"member_names"`
+	// "invite" or "qrcode"
+	Via string `json:"via"`
+}
 
 func (joinGroup JoinGroupType) AdapterName() string {
 	return OpenWechat.Name
@@ -168,5 +478,99 @@ func (joinGroup JoinGroupType) TypeName() string {
 }
 
 func (joinGroup JoinGroupType) ToRawText(msg message.MessageSegment) string {
-	return fmt.Sprintf("[OpenWechat:join_group]")
+	result := msg.Data.(JoinGroupType)
+	return fmt.Sprintf("[OpenWechat:join_group,inviter=%s,members=%s,via=%s]", result.InviterName, strings.Join(result.MemberNames, ","), result.Via)
+}
+
+type KickGroupType struct {
+	Operator     string   `json:"operator"`
+	OperatorName string   `json:"operator_name"`
+	Members      []string `json:"members"`
+	MemberNames  []string `json:"member_names"`
+}
+
+func (kickGroup KickGroupType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (kickGroup KickGroupType) TypeName() string {
+	return "kick_group"
+}
+
+func (kickGroup KickGroupType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(KickGroupType)
+	return fmt.Sprintf("[OpenWechat:kick_group,operator=%s,members=%s]", result.OperatorName, strings.Join(result.MemberNames, ","))
+}
+
+type LeaveGroupType struct {
+	Members     []string `json:"members"`
+	MemberNames []string `json:"member_names"`
+}
+
+func (leaveGroup LeaveGroupType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (leaveGroup LeaveGroupType) TypeName() string {
+	return "leave_group"
+}
+
+func (leaveGroup LeaveGroupType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(LeaveGroupType)
+	return fmt.Sprintf("[OpenWechat:leave_group,members=%s]", strings.Join(result.MemberNames, ","))
+}
+
+type RenameGroupType struct {
+	Operator     string `json:"operator"`
+	OperatorName string `json:"operator_name"`
+	Name         string `json:"name"`
+}
+
+func (renameGroup RenameGroupType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (renameGroup RenameGroupType) TypeName() string {
+	return "rename_group"
+}
+
+func (renameGroup RenameGroupType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(RenameGroupType)
+	return fmt.Sprintf("[OpenWechat:rename_group,operator=%s,name=%s]", result.OperatorName, result.Name)
+}
+
+type GroupOwnerType struct {
+	Owner     string `json:"owner"`
+	OwnerName string `json:"owner_name"`
+}
+
+func (groupOwner GroupOwnerType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (groupOwner GroupOwnerType) TypeName() string {
+	return "group_owner"
+}
+
+func (groupOwner GroupOwnerType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(GroupOwnerType)
+	return fmt.Sprintf("[OpenWechat:group_owner,owner=%s]", result.OwnerName)
+}
+
+type GroupAnnouncementType struct {
+	Operator     string `json:"operator"`
+	OperatorName string `json:"operator_name"`
+}
+
+func (groupAnnouncement GroupAnnouncementType) AdapterName() string {
+	return OpenWechat.Name
+}
+
+func (groupAnnouncement GroupAnnouncementType) TypeName() string {
+	return "group_announcement"
+}
+
+func (groupAnnouncement GroupAnnouncementType) ToRawText(msg message.MessageSegment) string {
+	result := msg.Data.(GroupAnnouncementType)
+	return fmt.Sprintf("[OpenWechat:group_announcement,operator=%s]", result.OperatorName)
 }
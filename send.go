@@ -0,0 +1,448 @@
+package openwechat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/gonebot-dev/gonebot/logging"
+	"github.com/gonebot-dev/gonebot/message"
+	"github.com/rs/zerolog"
+)
+
+// ErrContactNotFound is returned when the receiver or group of an outgoing message cannot be found.
+var ErrContactNotFound = errors.New("contact not found")
+
+// ErrNotLoggedIn is returned when sending before login or after shutdown.
+var ErrNotLoggedIn = errors.New("not logged in")
+
+// Get the logged-in account, Self is set to nil on shutdown while sends may still be running.
+func currentSelf() (*openwechat.Self, error) {
+	self := Self
+	if self == nil {
+		return nil, ErrNotLoggedIn
+	}
+	return self, nil
+}
+
+// ErrUnknownSource is returned when an outgoing media file is neither a url, base64, data uri nor an existing local path.
+var ErrUnknownSource = errors.New("unknown media source")
+
+func isURL(str string) bool {
+	return strings.HasPrefix(str, "http://") || strings.HasPrefix(str, "https://")
+}
+
+func isBase64Img(str string) bool {
+	return strings.HasPrefix(str, "base64://")
+}
+
+func isDataURI(str string) bool {
+	return strings.HasPrefix(str, "data:")
+}
+
+// Shorten a media source for logging, base64 data can be really long.
+func sourceName(file string) string {
+	if isBase64Img(file) {
+		return "base64"
+	}
+	if isDataURI(file) {
+		header, _, _ := strings.Cut(file, ",")
+		return header
+	}
+	return file
+}
+
+// Outgoing media loaded from url, base64, data uri or local path.
+type mediaData struct {
+	// Local file path, the file is reopened for every attempt so that its name is kept
+	path string
+	data []byte
+	// File name of fetched media
+	name string
+}
+
+// Decode a data uri like "data:image/png;base64,...".
+func decodeDataURI(uri string) ([]byte, error) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok {
+		return nil, errors.New("invalid data uri")
+	}
+	if strings.HasSuffix(header, ";base64") {
+		return base64.StdEncoding.DecodeString(payload)
+	}
+	data, err := url.PathUnescape(payload)
+	return []byte(data), err
+}
+
+// Convert a file:// url to a local path, other strings are returned as is.
+func localPath(file string) string {
+	if !strings.HasPrefix(file, "file://") {
+		return file
+	}
+	fileURL, err := url.Parse(file)
+	if err != nil {
+		return strings.TrimPrefix(file, "file://")
+	}
+	path := fileURL.Path
+	// file:///C:/path on windows
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
+
+// Load an outgoing media file from url, base64, data uri, file:// url or local path.
+func loadMedia(file string) (*mediaData, error) {
+	if isURL(file) {
+		return fetchMedia(file)
+	}
+	if isBase64Img(file) {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(file, "base64://"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode base64: %w", err)
+		}
+		return &mediaData{data: data}, nil
+	}
+	if isDataURI(file) {
+		data, err := decodeDataURI(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode data uri: %w", err)
+		}
+		return &mediaData{data: data}, nil
+	}
+	path := localPath(file)
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return &mediaData{path: path}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownSource, file)
+}
+
+// Write named media to a temp file so that the name is kept when it is sent as a file,
+// call cleanup after sending.
+func (m *mediaData) named() (media *mediaData, cleanup func(), err error) {
+	if m.path != "" || m.name == "" {
+		return m, func() {}, nil
+	}
+	tempDir, err := os.MkdirTemp("", "openwechat-file-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create temp folder: %w", err)
+	}
+	cleanup = func() { os.RemoveAll(tempDir) }
+	path := filepath.Join(tempDir, m.name)
+	if err = os.WriteFile(path, m.data, 0644); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("unable to write temp file: %w", err)
+	}
+	return &mediaData{path: path, name: m.name}, cleanup, nil
+}
+
+// Read all bytes of the media.
+func (m *mediaData) bytes() ([]byte, error) {
+	if m.path != "" {
+		return os.ReadFile(m.path)
+	}
+	return m.data, nil
+}
+
+// Open a reader of the media, close it after use.
+func (m *mediaData) open() (io.ReadCloser, error) {
+	if m.path != "" {
+		return os.Open(m.path)
+	}
+	return io.NopCloser(bytes.NewReader(m.data)), nil
+}
+
+// Send the media with retry, every attempt gets a fresh reader.
+func (m *mediaData) send(name, receiver, group string, send func(reader io.Reader) (*openwechat.SentMessage, error)) error {
+	return sendWithRetry(name, receiver, group, func() (*openwechat.SentMessage, error) {
+		reader, err := m.open()
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return send(reader)
+	})
+}
+
+// Search a friend by UserName, the file helper is used for the logged-in account itself.
+func searchFriend(self *openwechat.Self, friends openwechat.Friends, friendUserName string) *openwechat.Friend {
+	if friendUserName == openwechat.FileHelper || friendUserName == self.UserName {
+		return self.FileHelper()
+	}
+	return friends.SearchByUserName(1, friendUserName).First()
+}
+
+// Record the MsgId of a sent message so that it is not delivered again when it comes back.
+func recordSent(sent *openwechat.SentMessage, err error) error {
+	if err == nil && sent != nil {
+		sentMessageIDs.Add(sent.MsgId)
+	}
+	return err
+}
+
+// Log the error of a send helper and return it.
+func sendError(name string, err error) error {
+	if err != nil {
+		logging.Logf(zerolog.ErrorLevel, "OpenWechat", "%s: %s", name, err.Error())
+	}
+	return err
+}
+
+func sendImageToFriend(friendUserName string, img message.ImageType) error {
+	friend, err := findFriend(friendUserName)
+	if err != nil {
+		return sendError("sendImageToFriend", err)
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendImageToFriend: Image to friend %s: %s", friendUserName, sourceName(img.File))
+	media, err := loadMedia(img.File)
+	if err == nil {
+		media, err = prepareImage(media)
+	}
+	if err != nil {
+		return sendError("sendImageToFriend", err)
+	}
+	return sendError("sendImageToFriend", media.send("sendImageToFriend", friend.UserName, "", friend.SendImage))
+}
+
+func sendImageToGroup(groupUserName string, img message.ImageType) error {
+	group, err := findGroup(groupUserName)
+	if err != nil {
+		return sendError("sendImageToGroup", err)
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendImageToGroup: Image to group %s: %s", groupUserName, sourceName(img.File))
+	media, err := loadMedia(img.File)
+	if err == nil {
+		media, err = prepareImage(media)
+	}
+	if err != nil {
+		return sendError("sendImageToGroup", err)
+	}
+	return sendError("sendImageToGroup", media.send("sendImageToGroup", "", group.UserName, group.SendImage))
+}
+
+func sendVideoToFriend(friendUserName string, video message.VideoType) error {
+	friend, err := findFriend(friendUserName)
+	if err != nil {
+		return sendError("sendVideoToFriend", err)
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendVideoToFriend: Video to friend %s: %s", friendUserName, sourceName(video.File))
+	media, err := loadMedia(video.File)
+	if err != nil {
+		return sendError("sendVideoToFriend", err)
+	}
+	return sendError("sendVideoToFriend", media.send("sendVideoToFriend", friend.UserName, "", friend.SendVideo))
+}
+
+func sendVideoToGroup(groupUserName string, video message.VideoType) error {
+	group, err := findGroup(groupUserName)
+	if err != nil {
+		return sendError("sendVideoToGroup", err)
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendVideoToGroup: Video to group %s: %s", groupUserName, sourceName(video.File))
+	media, err := loadMedia(video.File)
+	if err != nil {
+		return sendError("sendVideoToGroup", err)
+	}
+	return sendError("sendVideoToGroup", media.send("sendVideoToGroup", "", group.UserName, group.SendVideo))
+}
+
+func sendStickerToFriend(friendUserName string, sticker StickerType) error {
+	self, err := currentSelf()
+	if err != nil {
+		return sendError("sendStickerToFriend", err)
+	}
+	friend, err := findFriend(friendUserName)
+	if err != nil {
+		return sendError("sendStickerToFriend", err)
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendStickerToFriend: Sticker to friend %s: %s", friendUserName, sticker.MD5)
+	// Re-send by md5 first, upload the file only if it fails
+	if sticker.MD5 != "" {
+		err = sendWithRetry("sendStickerToFriend", friend.UserName, "", func() (*openwechat.SentMessage, error) {
+			return self.SendEmoticonToFriend(friend, sticker.MD5, nil)
+		})
+		if err == nil || sticker.File == "" {
+			return sendError("sendStickerToFriend", err)
+		}
+		logging.Logf(zerolog.WarnLevel, "OpenWechat", "sendStickerToFriend: Unable to send sticker by md5: %s", err.Error())
+	}
+	media, err := loadMedia(sticker.File)
+	if err != nil {
+		return sendError("sendStickerToFriend", err)
+	}
+	return sendError("sendStickerToFriend", media.send("sendStickerToFriend", friend.UserName, "", func(reader io.Reader) (*openwechat.SentMessage, error) {
+		return self.SendEmoticonToFriend(friend, "", reader)
+	}))
+}
+
+func sendStickerToGroup(groupUserName string, sticker StickerType) error {
+	self, err := currentSelf()
+	if err != nil {
+		return sendError("sendStickerToGroup", err)
+	}
+	group, err := findGroup(groupUserName)
+	if err != nil {
+		return sendError("sendStickerToGroup", err)
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendStickerToGroup: Sticker to group %s: %s", groupUserName, sticker.MD5)
+	// Re-send by md5 first, upload the file only if it fails
+	if sticker.MD5 != "" {
+		err = sendWithRetry("sendStickerToGroup", "", group.UserName, func() (*openwechat.SentMessage, error) {
+			return self.SendEmoticonToGroup(group, sticker.MD5, nil)
+		})
+		if err == nil || sticker.File == "" {
+			return sendError("sendStickerToGroup", err)
+		}
+		logging.Logf(zerolog.WarnLevel, "OpenWechat", "sendStickerToGroup: Unable to send sticker by md5: %s", err.Error())
+	}
+	media, err := loadMedia(sticker.File)
+	if err != nil {
+		return sendError("sendStickerToGroup", err)
+	}
+	return sendError("sendStickerToGroup", media.send("sendStickerToGroup", "", group.UserName, func(reader io.Reader) (*openwechat.SentMessage, error) {
+		return self.SendEmoticonToGroup(group, "", reader)
+	}))
+}
+
+func sendFileToFriend(friendUserName string, f message.FileType) error {
+	friend, err := findFriend(friendUserName)
+	if err != nil {
+		return sendError("sendFileToFriend", err)
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendFileToFriend: File to friend %s: %s", friendUserName, sourceName(f.File))
+	media, err := loadMedia(f.File)
+	if err != nil {
+		return sendError("sendFileToFriend", err)
+	}
+	media, cleanup, err := media.named()
+	if err != nil {
+		return sendError("sendFileToFriend", err)
+	}
+	defer cleanup()
+	return sendError("sendFileToFriend", media.send("sendFileToFriend", friend.UserName, "", friend.SendFile))
+}
+
+func sendFileToGroup(groupUserName string, f message.FileType) error {
+	group, err := findGroup(groupUserName)
+	if err != nil {
+		return sendError("sendFileToGroup", err)
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendFileToGroup: File to group %s: %s", groupUserName, sourceName(f.File))
+	media, err := loadMedia(f.File)
+	if err != nil {
+		return sendError("sendFileToGroup", err)
+	}
+	media, cleanup, err := media.named()
+	if err != nil {
+		return sendError("sendFileToGroup", err)
+	}
+	defer cleanup()
+	return sendError("sendFileToGroup", media.send("sendFileToGroup", "", group.UserName, group.SendFile))
+}
+
+func sendTextToFriend(friendUserName string, text string) error {
+	friend, err := findFriend(friendUserName)
+	if err != nil {
+		return sendError("sendTextToFriend", err)
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendTextToFriend: Text to friend %s: %s", friendUserName, text)
+	return sendError("sendTextToFriend", sendWithRetry("sendTextToFriend", friend.UserName, "", func() (*openwechat.SentMessage, error) {
+		return friend.SendText(text)
+	}))
+}
+
+func sendTextToGroup(groupUserName string, text string) error {
+	group, err := findGroup(groupUserName)
+	if err != nil {
+		return sendError("sendTextToGroup", err)
+	}
+	logging.Logf(zerolog.InfoLevel, "OpenWechat", "sendTextToGroup: Text to group %s: %s", groupUserName, text)
+	return sendError("sendTextToGroup", sendWithRetry("sendTextToGroup", "", group.UserName, func() (*openwechat.SentMessage, error) {
+		return group.SendText(text)
+	}))
+}
+
+func sendImage(receiver, group string, img message.ImageType) error {
+	if group == "" {
+		return sendImageToFriend(receiver, img)
+	}
+	return sendImageToGroup(group, img)
+}
+
+func sendVideo(receiver, group string, video message.VideoType) error {
+	if group == "" {
+		return sendVideoToFriend(receiver, video)
+	}
+	return sendVideoToGroup(group, video)
+}
+
+func sendSticker(receiver, group string, sticker StickerType) error {
+	if group == "" {
+		return sendStickerToFriend(receiver, sticker)
+	}
+	return sendStickerToGroup(group, sticker)
+}
+
+func sendFile(receiver, group string, f message.FileType) error {
+	if group == "" {
+		return sendFileToFriend(receiver, f)
+	}
+	return sendFileToGroup(group, f)
+}
+
+// WeChat web cannot send voice messages, so voice is sent as a file attachment instead.
+func sendVoice(receiver, group string, voice message.VoiceType) error {
+	media, err := loadMedia(voice.File)
+	if err != nil {
+		return sendError("sendVoice", err)
+	}
+	if media.path != "" {
+		return sendFile(receiver, group, message.FileType{File: media.path})
+	}
+	// Name the file after its format so that it can be played after downloading
+	tempDir, err := os.MkdirTemp("", "openwechat-voice-*")
+	if err != nil {
+		return sendError("sendVoice", fmt.Errorf("unable to create temp folder: %w", err))
+	}
+	defer os.RemoveAll(tempDir)
+	voiceName := "voice"
+	if format := detectVoiceFormat(media.data); format != "unknown" {
+		voiceName += "." + format
+	}
+	voicePath := filepath.Join(tempDir, voiceName)
+	if err = os.WriteFile(voicePath, media.data, 0644); err != nil {
+		return sendError("sendVoice", fmt.Errorf("unable to write voice file: %w", err))
+	}
+	return sendFile(receiver, group, message.FileType{File: voicePath})
+}
+
+// Long text is split into several messages, sending stops at the first part that fails.
+func sendText(receiver, group string, text string) error {
+	parts := splitOutgoingText(text)
+	for i, part := range parts {
+		if i > 0 {
+			time.Sleep(TextSplitInterval)
+		}
+		var err error
+		if group == "" {
+			err = sendTextToFriend(receiver, part)
+		} else {
+			err = sendTextToGroup(group, part)
+		}
+		if err != nil {
+			if len(parts) > 1 {
+				return fmt.Errorf("part %d/%d: %w", i+1, len(parts), err)
+			}
+			return err
+		}
+	}
+	return nil
+}
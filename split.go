@@ -0,0 +1,169 @@
+package openwechat
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TextSplitLimit is the max number of characters in one text message, longer text is split into several messages,
+// set it to 0 to disable splitting.
+var TextSplitLimit = 2000
+
+// TextSplitNumbering appends numbering like " (1/3)" to every part of a split text.
+var TextSplitNumbering = false
+
+// TextSplitInterval is the pause between the parts of a split text.
+var TextSplitInterval = time.Second
+
+// Emoji codes and @mentions must not be split.
+var unbreakablePattern = regexp.MustCompile(`\[[^\[\]\s]{1,16}\]|@[^\s@\x{2005}]{1,32}\x{2005}?`)
+
+// Room reserved for numbering, enough for " (999/999)".
+const splitNumberingRoom = 10
+
+// Boundaries of a text in rune offsets.
+type textBoundaries struct {
+	// Positions inside an unbreakable span map to the start of the span
+	spanStart map[int]int
+	spanEnd   map[int]int
+}
+
+func findBoundaries(text string) textBoundaries {
+	bounds := textBoundaries{spanStart: make(map[int]int), spanEnd: make(map[int]int)}
+	for _, loc := range unbreakablePattern.FindAllStringIndex(text, -1) {
+		start := len([]rune(text[:loc[0]]))
+		end := start + len([]rune(text[loc[0]:loc[1]]))
+		for i := start + 1; i < end; i++ {
+			bounds.spanStart[i] = start
+			bounds.spanEnd[i] = end
+		}
+	}
+	return bounds
+}
+
+func (b textBoundaries) breakable(pos int) bool {
+	_, inside := b.spanStart[pos]
+	return !inside
+}
+
+func isSentenceEnd(runes []rune, i int) bool {
+	switch runes[i] {
+	case '。', '！', '？', '；', '…', '!', '?', ';':
+		return true
+	case '.':
+		return i+1 >= len(runes) || unicode.IsSpace(runes[i+1])
+	}
+	return false
+}
+
+// A place to cut a text, the separator between end and next is dropped.
+type textCut struct {
+	end  int
+	next int
+}
+
+// Find where to cut runes[start:], preferring paragraph, line, sentence and word boundaries in this order.
+func findCut(runes []rune, bounds textBoundaries, start, limit int) textCut {
+	end := start + limit
+	// Each rule returns the length of the separator before i, or -1 if i is not such a boundary
+	rules := []func(i int) int{
+		func(i int) int {
+			if i >= 2 && runes[i-1] == '\n' && runes[i-2] == '\n' {
+				return 2
+			}
+			return -1
+		},
+		func(i int) int {
+			if runes[i-1] == '\n' {
+				return 1
+			}
+			return -1
+		},
+		func(i int) int {
+			if isSentenceEnd(runes, i-1) {
+				return 0
+			}
+			return -1
+		},
+		func(i int) int {
+			// The space after a mention belongs to the mention
+			if unicode.IsSpace(runes[i-1]) && runes[i-1] != '\u2005' {
+				return 1
+			}
+			return -1
+		},
+	}
+	// Boundaries too close to the start make tiny parts, so they are only used when there are no others
+	for _, lowest := range []int{start + limit/2, start} {
+		for _, rule := range rules {
+			for i := end; i > lowest; i-- {
+				if !bounds.breakable(i) {
+					continue
+				}
+				separator := rule(i)
+				if separator < 0 || i-separator <= start {
+					continue
+				}
+				if separator > 0 {
+					return textCut{end: i - separator, next: i}
+				} else if separator == 0 {
+					// Drop the space between sentences
+					if i < len(runes) && runes[i] == ' ' {
+						return textCut{end: i, next: i + 1}
+					}
+					return textCut{end: i, next: i}
+				}
+			}
+		}
+	}
+	// Hard cut, move out of unbreakable spans
+	cut := bounds.spanEnd[end]
+	if bounds.breakable(end) {
+		cut = end
+	} else if spanStart := bounds.spanStart[end]; spanStart > start {
+		cut = spanStart
+	}
+	return textCut{end: cut, next: cut}
+}
+
+// Split text into parts of at most limit characters, unbreakable spans longer than the limit are kept whole.
+// Only the separators at the cuts are dropped, so indentation and blank lines inside parts are kept.
+func splitText(text string, limit int) []string {
+	runes := []rune(text)
+	if limit <= 0 || len(runes) <= limit {
+		return []string{text}
+	}
+	bounds := findBoundaries(text)
+	parts := []string{}
+	for start := 0; start < len(runes); {
+		cut := textCut{end: len(runes), next: len(runes)}
+		if len(runes)-start > limit {
+			cut = findCut(runes, bounds, start, limit)
+		}
+		// Blank parts cannot be sent
+		if part := string(runes[start:cut.end]); strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+		start = cut.next
+	}
+	return parts
+}
+
+// Split text with TextSplitLimit and add numbering if TextSplitNumbering is set.
+func splitOutgoingText(text string) []string {
+	limit := TextSplitLimit
+	if !TextSplitNumbering || limit <= 0 || len([]rune(text)) <= limit {
+		return splitText(text, limit)
+	}
+	parts := splitText(text, max(limit-splitNumberingRoom, 1))
+	if len(parts) == 1 {
+		return parts
+	}
+	for i := range parts {
+		parts[i] = fmt.Sprintf("%s (%d/%d)", parts[i], i+1, len(parts))
+	}
+	return parts
+}
@@ -0,0 +1,201 @@
+package openwechat
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/gonebot-dev/gonebot/message"
+)
+
+// RecentMessageLimit is the max number of recent messages kept for recall events.
+var RecentMessageLimit = 1024
+
+type recentMessage struct {
+	id  string
+	msg message.Message
+}
+
+// A bounded store of recent incoming messages keyed by MsgId, the oldest message is dropped first.
+type recentMessageStore struct {
+	lock  sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+}
+
+var recentMessages = &recentMessageStore{
+	order: list.New(),
+	items: make(map[string]*list.Element),
+}
+
+// Drop inline media data like base64 images, stickers and voice so that stored messages stay small,
+// urls and local paths are kept.
+func withoutMediaPayloads(msg message.Message) message.Message {
+	stripped := message.NewMessage()
+	stripped.IsToMe = msg.IsToMe
+	stripped.Group = msg.Group
+	stripped.Sender = msg.Sender
+	stripped.Receiver = msg.Receiver
+	stripped.Self = msg.Self
+	isPayload := func(file string) bool {
+		return isBase64Img(file) || isDataURI(file)
+	}
+	for _, segment := range msg.GetSegments() {
+		switch data := segment.Data.(type) {
+		case message.ImageType:
+			if isPayload(data.File) {
+				data.File = ""
+			}
+			segment.Data = data
+		case message.VoiceType:
+			if isPayload(data.File) {
+				data.File = ""
+			}
+			segment.Data = data
+		case message.VideoType:
+			if isPayload(data.File) {
+				data.File = ""
+			}
+			segment.Data = data
+		case message.FileType:
+			if isPayload(data.File) {
+				data.File = ""
+			}
+			segment.Data = data
+		case StickerType:
+			if isPayload(data.File) {
+				data.File = ""
+			}
+			segment.Data = data
+		}
+		stripped.AttachSegment(segment)
+	}
+	return *stripped
+}
+
+// Put a message into the store, inline media data is dropped.
+func (s *recentMessageStore) Put(id string, msg message.Message) {
+	if id == "" || RecentMessageLimit <= 0 {
+		return
+	}
+	msg = withoutMediaPayloads(msg)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if element, ok := s.items[id]; ok {
+		element.Value.(*recentMessage).msg = msg
+		s.order.MoveToBack(element)
+		return
+	}
+	s.items[id] = s.order.PushBack(&recentMessage{id: id, msg: msg})
+	for s.order.Len() > RecentMessageLimit {
+		oldest := s.order.Front()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*recentMessage).id)
+	}
+}
+
+// Get a message from the store.
+func (s *recentMessageStore) Get(id string) (message.Message, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	element, ok := s.items[id]
+	if !ok {
+		return message.Message{}, false
+	}
+	return element.Value.(*recentMessage).msg, true
+}
+
+// Clear the store.
+func (s *recentMessageStore) Clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.order.Init()
+	s.items = make(map[string]*list.Element)
+}
+
+// A bounded set of MsgIds sent by the adapter, the oldest id is dropped first.
+type messageIDSet struct {
+	lock  sync.Mutex
+	limit int
+	order *list.List
+	items map[string]*list.Element
+}
+
+var sentMessageIDs = &messageIDSet{
+	limit: 1024,
+	order: list.New(),
+	items: make(map[string]*list.Element),
+}
+
+// Add an id into the set.
+func (s *messageIDSet) Add(id string) {
+	if id == "" {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.items[id]; ok {
+		return
+	}
+	s.items[id] = s.order.PushBack(id)
+	for s.order.Len() > s.limit {
+		oldest := s.order.Front()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(string))
+	}
+}
+
+// Check if an id is in the set.
+func (s *messageIDSet) Has(id string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.items[id]
+	return ok
+}
+
+// Clear the set.
+func (s *messageIDSet) Clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.order.Init()
+	s.items = make(map[string]*list.Element)
+}
+
+var unknownMessageCounts = make(map[string]int)
+var unknownMessageLock sync.Mutex
+
+// Count a message of unknown type.
+func countUnknownMessage(msgType, appMsgType int) {
+	unknownMessageLock.Lock()
+	unknownMessageCounts[fmt.Sprintf("%d:%d", msgType, appMsgType)]++
+	unknownMessageLock.Unlock()
+}
+
+// UnknownMessageCounts returns how many messages of each unknown type have been received,
+// keyed by "MsgType:AppMsgType".
+func UnknownMessageCounts() map[string]int {
+	unknownMessageLock.Lock()
+	defer unknownMessageLock.Unlock()
+	result := make(map[string]int, len(unknownMessageCounts))
+	for key, count := range unknownMessageCounts {
+		result[key] = count
+	}
+	return result
+}
+
+// GetMessage returns a recent incoming message by its MsgId,
+// the File of base64 images, stickers, voice, videos and files is empty.
+func GetMessage(msgID string) (message.Message, bool) {
+	return recentMessages.Get(msgID)
+}
+
+// GetMetadata returns the metadata attached to an incoming message.
+func GetMetadata(msg message.Message) (MetadataType, bool) {
+	segments := msg.GetSegments()
+	for i := len(segments) - 1; i >= 0; i-- {
+		if metadata, ok := segments[i].Data.(MetadataType); ok {
+			return metadata, true
+		}
+	}
+	return MetadataType{}, false
+}
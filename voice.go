@@ -0,0 +1,66 @@
+package openwechat
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/gonebot-dev/gonebot/logging"
+	"github.com/rs/zerolog"
+)
+
+// VoiceDecoder converts voice data of the given format (e.g. "silk") into a standard format,
+// returning the converted data and its new format (e.g. "wav" or "mp3").
+//
+// Leave it nil to deliver voice messages as they are received.
+var VoiceDecoder func(data []byte, format string) ([]byte, string, error)
+
+// Detect the audio format of voice data by its header.
+func detectVoiceFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("#!SILK_V3")), bytes.HasPrefix(data, []byte("\x02#!SILK_V3")):
+		return "silk"
+	case bytes.HasPrefix(data, []byte("#!AMR")):
+		return "amr"
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		return "wav"
+	case bytes.HasPrefix(data, []byte("ID3")), len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0:
+		return "mp3"
+	default:
+		return "unknown"
+	}
+}
+
+// PCMToWAV wraps raw little-endian PCM samples with a WAV header,
+// useful for VoiceDecoder implementations that only produce PCM.
+func PCMToWAV(pcm []byte, sampleRate, channels, bitsPerSample int) []byte {
+	blockAlign := channels * bitsPerSample / 8
+	buf := bytes.NewBuffer(make([]byte, 0, 44+len(pcm)))
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(buf, binary.LittleEndian, uint32(16))
+	binary.Write(buf, binary.LittleEndian, uint16(1))
+	binary.Write(buf, binary.LittleEndian, uint16(channels))
+	binary.Write(buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(buf, binary.LittleEndian, uint32(sampleRate*blockAlign))
+	binary.Write(buf, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(buf, binary.LittleEndian, uint16(bitsPerSample))
+	buf.WriteString("data")
+	binary.Write(buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
+
+// Convert voice data to a standard format if possible, returns the result and its format.
+func transcodeVoice(data []byte) ([]byte, string) {
+	format := detectVoiceFormat(data)
+	if VoiceDecoder == nil || format == "mp3" || format == "wav" {
+		return data, format
+	}
+	converted, newFormat, err := VoiceDecoder(data, format)
+	if err != nil {
+		logging.Logf(zerolog.WarnLevel, "OpenWechat", "transcodeVoice: Unable to decode %s voice: %s", format, err.Error())
+		return data, format
+	}
+	return converted, newFormat
+}